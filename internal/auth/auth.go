@@ -1,30 +1,16 @@
 package auth
 
 import (
-	"encoding/base64"
 	"log"
+	"net"
 	"net/http"
-	"strings"
-	"sync"
+	"net/url"
+	"strconv"
 	"time"
-)
-
-// BanIP represents a banned IP address with a count and timestamp
-type BanIP struct {
-	Count int
-	Ts    int
-}
 
-var (
-	// AutoBan stores banned IP addresses
-	AutoBan     map[string]BanIP
-	autobanLock sync.Mutex
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
 )
 
-func init() {
-	AutoBan = make(map[string]BanIP, 10)
-}
-
 func AskForAuth(w http.ResponseWriter) {
 	w.Header().Set("WWW-Authenticate", `Basic realm="Please Login"`)
 	w.WriteHeader(http.StatusUnauthorized)
@@ -34,84 +20,135 @@ func AskForAuth(w http.ResponseWriter) {
 	}
 }
 
-// ProcAutoBan processes auto-banning of IP addresses
-func ProcAutoBan(banTimeout, banCount int, r *http.Request) bool {
-	autobanLock.Lock()
-	defer autobanLock.Unlock()
-
-	remoteAddr := r.RemoteAddr
-	if idx := strings.LastIndex(remoteAddr, ":"); idx != -1 {
-		remoteAddr = remoteAddr[:idx]
-	}
-
-	v, ok := AutoBan[remoteAddr]
-	if !ok {
-		AutoBan[remoteAddr] = BanIP{
-			Count: 1,
-			Ts:    int(time.Now().Unix()),
-		}
-		return false
-	}
-
-	// IP exists in ban list
-	now := int(time.Now().Unix())
-	if now-v.Ts > banTimeout {
-		// Ban timeout reset
-		AutoBan[remoteAddr] = BanIP{
-			Count: 1,
-			Ts:    now,
-		}
-		return false
+// TooManyAttempts tells a banned client when it may try again
+func TooManyAttempts(w http.ResponseWriter, left time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(left.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, err := w.Write([]byte("Too Many Failed Auth Attempts.\n"))
+	if err != nil {
+		log.Println(err)
 	}
+}
 
-	v.Count++
-	v.Ts = now
-	AutoBan[remoteAddr] = v
+// Guard protects routes according to their Policy
+type Guard struct {
+	Users    *Users
+	NoAuth   bool
+	Bans     *Banner
+	Sessions *SessionStore
+	Devices  *Devices
+}
 
-	if v.Count > banCount {
-		log.Printf("BAN IP[%s], count:%d, ts:%d\n", remoteAddr, v.Count, v.Ts)
-		return true
+// NewGuard creates a Guard checking logins against users, sessions, bans and
+// devices may be nil. With noAuth every policy but localhost lets everybody in
+func NewGuard(users *Users, noAuth bool, bans *Banner, sessions *SessionStore, devices *Devices) *Guard {
+	return &Guard{
+		Users:    users,
+		NoAuth:   noAuth,
+		Bans:     bans,
+		Sessions: sessions,
+		Devices:  devices,
 	}
-
-	return false
 }
 
-func Middleware(next http.Handler, authStr string, noAuth bool, banTimeout, banCount int) http.Handler {
+// Protect lets requests through to next only if they satisfy p
+func (g *Guard) Protect(p Policy, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if noAuth {
+		switch {
+		case p == PolicyPublic:
 			next.ServeHTTP(w, r)
 			return
-		}
-
-		if ProcAutoBan(banTimeout, banCount, r) {
-			w.WriteHeader(http.StatusTooManyRequests)
-			_, err := w.Write([]byte("Too Many Failed Auth Attempts.\n"))
-			if err != nil {
-				log.Println(err)
+		case p == PolicyLocalhost:
+			if !utils.IsLocalIP(net.ParseIP(utils.RemoteIP(r))) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
 			}
+			next.ServeHTTP(w, r)
 			return
-		}
-
-		s := r.Header.Get("Authorization")
-		if s == "" {
-			AskForAuth(w)
+		case g.NoAuth:
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		if !strings.HasPrefix(s, "Basic ") {
-			AskForAuth(w)
+		r, ok := g.authenticate(w, r)
+		if !ok {
 			return
 		}
-
-		if s[6:] != authStr {
-			AskForAuth(w)
+		if perm, ok := p.Permission(); ok && !UserFromRequest(r).Can(perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
-
 		next.ServeHTTP(w, r)
 	})
 }
 
-func CalcAuthStr(usr, pwd string) string {
-	return base64.StdEncoding.EncodeToString([]byte(usr + ":" + pwd))
+// authenticate requires a valid session cookie or Basic Auth credentials and
+// returns r with the user in its context. Approved devices get a new session
+// from their device token, other browsers without a session are sent to the
+// login page, scripts may keep using Basic Auth. When it returns false the
+// response has been written
+func (g *Guard) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	sessions, users, bans := g.Sessions, g.Users, g.Bans
+	if sessions != nil {
+		if name, ok := sessions.User(r); ok {
+			if user, ok := users.Get(name); ok {
+				if !sessions.CheckCSRF(r) {
+					http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+					return r, false
+				}
+				return WithUser(sessions.WithCSRFToken(r), user), true
+			}
+		}
+	}
+
+	if g.Devices != nil && sessions != nil && r.Method == http.MethodGet {
+		if c, err := r.Cookie(DeviceCookieName); err == nil {
+			if dev, ok := g.Devices.Authenticate(c.Value); ok {
+				if _, ok := users.Get(dev.User); ok {
+					if err := sessions.CreateForDevice(w, r, dev.User, dev.ID); err != nil {
+						http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+						return r, false
+					}
+					// load the page again, now with the session cookie
+					http.Redirect(w, r, utils.RequestBaseURI(r)+r.URL.RequestURI(), http.StatusSeeOther)
+					return r, false
+				}
+			}
+		}
+	}
+
+	name, pwd, hasBasic := r.BasicAuth()
+	if !hasBasic && sessions != nil && sessions.LoginURL != "" {
+		if r.Method == http.MethodGet {
+			http.Redirect(w, r, utils.RequestBaseURI(r)+sessions.LoginURL+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return r, false
+		}
+		// no WWW-Authenticate here, it would make browsers prompt for Basic Auth
+		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		return r, false
+	}
+
+	ip := utils.RemoteIP(r)
+	if left := bans.Banned(ip); left > 0 {
+		TooManyAttempts(w, left)
+		return r, false
+	}
+
+	if !hasBasic {
+		AskForAuth(w)
+		return r, false
+	}
+
+	user, ok := users.Authenticate(name, pwd)
+	if !ok {
+		if bans.Fail(ip) {
+			TooManyAttempts(w, bans.Banned(ip))
+			return r, false
+		}
+		AskForAuth(w)
+		return r, false
+	}
+
+	bans.Succeed(ip)
+	return WithUser(r, user), true
 }
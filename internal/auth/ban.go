@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+// DefaultMaxBanTime caps the exponential backoff of repeated bans
+const DefaultMaxBanTime = 24 * time.Hour
+
+// ErrInvalidAddress is returned for allowlist entries that are neither an IP nor a CIDR
+var ErrInvalidAddress = errors.New("invalid IP address or CIDR")
+
+// Banner bans client addresses after too many failed logins. Each further
+// ban of the same address lasts twice as long as the previous one, up to
+// MaxBanTime, and the history is forgotten after MaxBanTime without failures
+type Banner struct {
+	// Threshold is the number of failures within Window that triggers a ban
+	Threshold int
+	// Window is how long a failure is remembered
+	Window time.Duration
+	// BanTime is the length of the first ban
+	BanTime time.Duration
+	// MaxBanTime caps the length of a ban
+	MaxBanTime time.Duration
+	// Now returns the current time, tests may replace it
+	Now func() time.Time
+
+	entries map[string]*BanInfo
+	allow   map[string]*net.IPNet
+	// static marks allowlist entries from the command line, they are not saved
+	static map[string]bool
+	path   string
+	mutex  sync.Mutex
+}
+
+// BanInfo is the failure and ban history of one address
+type BanInfo struct {
+	Addr         string    `json:"addr"`
+	Failures     int       `json:"failures"`
+	FirstFailure time.Time `json:"first_failure"`
+	LastFailure  time.Time `json:"last_failure"`
+	BannedUntil  time.Time `json:"banned_until"`
+	Bans         int       `json:"bans"`
+}
+
+// banFile is the JSON layout of a persisted Banner
+type banFile struct {
+	Entries []BanInfo `json:"entries"`
+	Allow   []string  `json:"allow"`
+}
+
+// NewBanner creates a Banner banning an address for banTime once it failed
+// threshold times within banTime, threshold < 1 disables banning
+func NewBanner(threshold int, banTime time.Duration) *Banner {
+	return &Banner{
+		Threshold:  threshold,
+		Window:     banTime,
+		BanTime:    banTime,
+		MaxBanTime: DefaultMaxBanTime,
+		Now:        time.Now,
+		entries:    make(map[string]*BanInfo),
+		allow:      make(map[string]*net.IPNet),
+		static:     make(map[string]bool),
+	}
+}
+
+// Persist loads the ban list saved at path and saves it there after every
+// ban, unban or allowlist change
+func (b *Banner) Persist(path string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read ban list err: %w", err)
+	}
+
+	var f banFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("decode ban list err: %w", err)
+	}
+	for i := range f.Entries {
+		e := f.Entries[i]
+		b.entries[e.Addr] = &e
+	}
+	for _, addr := range f.Allow {
+		if err := b.allowAddr(addr); err != nil {
+			return fmt.Errorf("decode ban list err: %w", err)
+		}
+	}
+	return nil
+}
+
+// Banned returns how much longer addr is banned, 0 if it is not
+func (b *Banner) Banned(addr string) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	e, ok := b.entries[addr]
+	if !ok || b.allowed(addr) {
+		return 0
+	}
+	if left := e.BannedUntil.Sub(b.Now()); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// Fail records a failed login of addr and reports whether addr is banned now
+func (b *Banner) Fail(addr string) bool {
+	if b == nil || b.Threshold < 1 {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.allowed(addr) {
+		return false
+	}
+
+	now := b.Now()
+	e, ok := b.entries[addr]
+	if !ok {
+		e = &BanInfo{Addr: addr}
+		b.entries[addr] = e
+	}
+	if now.Before(e.BannedUntil) {
+		return true
+	}
+	if now.Sub(e.LastFailure) > b.MaxBanTime {
+		e.Bans = 0
+	}
+	if now.Sub(e.FirstFailure) > b.Window {
+		e.Failures = 0
+		e.FirstFailure = now
+	}
+	e.Failures++
+	e.LastFailure = now
+
+	if e.Failures >= b.Threshold {
+		d := b.banDuration(e.Bans)
+		e.BannedUntil = now.Add(d)
+		e.Bans++
+		e.Failures = 0
+		log.Printf("BAN IP[%s] for %s, ban #%d", addr, d, e.Bans)
+		if err := b.save(); err != nil {
+			log.Println(err)
+		}
+	}
+	b.prune(now, addr)
+	return now.Before(e.BannedUntil)
+}
+
+// Succeed clears the failures of addr after a successful login, earlier
+// bans still count towards the backoff
+func (b *Banner) Succeed(addr string) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if e, ok := b.entries[addr]; ok {
+		e.Failures = 0
+		if e.Bans == 0 {
+			delete(b.entries, addr)
+		}
+	}
+}
+
+// Ban bans addr for d, regardless of its failures
+func (b *Banner) Ban(addr string, d time.Duration) error {
+	if net.ParseIP(addr) == nil {
+		return ErrInvalidAddress
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	e, ok := b.entries[addr]
+	if !ok {
+		e = &BanInfo{Addr: addr}
+		b.entries[addr] = e
+	}
+	e.BannedUntil = b.Now().Add(d)
+	e.Bans++
+	log.Printf("BAN IP[%s] for %s, set manually", addr, d)
+	return b.save()
+}
+
+// Unban forgets the failures and bans of addr
+func (b *Banner) Unban(addr string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.entries, addr)
+	log.Printf("UNBAN IP[%s]", addr)
+	return b.save()
+}
+
+// List returns the addresses with failures or bans, most recent first
+func (b *Banner) List() []BanInfo {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	list := make([]BanInfo, 0, len(b.entries))
+	for _, e := range b.entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastFailure.After(list[j].LastFailure)
+	})
+	return list
+}
+
+// Allow adds an IP or CIDR to the allowlist, allowed addresses are never banned
+func (b *Banner) Allow(addr string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.allowAddr(addr); err != nil {
+		return err
+	}
+	delete(b.static, addr)
+	return b.save()
+}
+
+// AllowStatic adds an IP or CIDR to the allowlist like Allow, but keeps it
+// in memory only. Entries already saved stay saved
+func (b *Banner) AllowStatic(addr string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.allow[addr]; ok {
+		return nil
+	}
+	if err := b.allowAddr(addr); err != nil {
+		return err
+	}
+	b.static[addr] = true
+	return nil
+}
+
+// Disallow removes an entry added by Allow or AllowStatic
+func (b *Banner) Disallow(addr string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.allow, addr)
+	delete(b.static, addr)
+	return b.save()
+}
+
+// Allowlist returns the allowlist entries, sorted
+func (b *Banner) Allowlist() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	list := make([]string, 0, len(b.allow))
+	for addr := range b.allow {
+		list = append(list, addr)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// allowAddr parses addr and adds it to the allowlist, the caller holds the lock
+func (b *Banner) allowAddr(addr string) error {
+	if ip := net.ParseIP(addr); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		b.allow[addr] = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return ErrInvalidAddress
+	}
+	b.allow[addr] = ipNet
+	return nil
+}
+
+// allowed reports whether addr is on the allowlist, the caller holds the lock
+func (b *Banner) allowed(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range b.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// banDuration returns BanTime doubled for every earlier ban, capped at MaxBanTime
+func (b *Banner) banDuration(earlierBans int) time.Duration {
+	d := b.BanTime
+	for i := 0; i < earlierBans && d < b.MaxBanTime; i++ {
+		d *= 2
+	}
+	if d > b.MaxBanTime {
+		d = b.MaxBanTime
+	}
+	return d
+}
+
+// prune forgets stale addresses now and then to keep the map small, the
+// caller holds the lock
+func (b *Banner) prune(now time.Time, keep string) {
+	if len(b.entries) <= 1024 {
+		return
+	}
+	for addr, e := range b.entries {
+		if addr != keep && now.After(e.BannedUntil) && now.Sub(e.LastFailure) > b.MaxBanTime {
+			delete(b.entries, addr)
+		}
+	}
+}
+
+// save writes the ban list to the file given to Persist, if any, the
+// caller holds the lock
+func (b *Banner) save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	f := banFile{
+		Entries: make([]BanInfo, 0, len(b.entries)),
+		Allow:   make([]string, 0, len(b.allow)),
+	}
+	for _, e := range b.entries {
+		if !e.BannedUntil.IsZero() {
+			f.Entries = append(f.Entries, *e)
+		}
+	}
+	for addr := range b.allow {
+		if !b.static[addr] {
+			f.Allow = append(f.Allow, addr)
+		}
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("encode ban list err: %w", err)
+	}
+
+	if err := utils.WriteFileAtomic(b.path, data, 0600); err != nil {
+		return fmt.Errorf("write ban list err: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testAddr = "192.0.2.10"
+
+// fakeClock is a Banner.Now that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestBanner returns a Banner banning after 3 failures within a minute,
+// for a minute at first and for 8 minutes at most
+func newTestBanner() (*Banner, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	b := NewBanner(3, time.Minute)
+	b.MaxBanTime = 8 * time.Minute
+	b.Now = clock.Now
+	return b, clock
+}
+
+// failUntilBanned fails addr Threshold times and returns the ban length
+func failUntilBanned(t *testing.T, b *Banner, addr string) time.Duration {
+	t.Helper()
+	for i := 1; i < b.Threshold; i++ {
+		if b.Fail(addr) {
+			t.Fatalf("banned after %d failures, threshold is %d", i, b.Threshold)
+		}
+	}
+	if !b.Fail(addr) {
+		t.Fatalf("not banned after %d failures", b.Threshold)
+	}
+	return b.Banned(addr)
+}
+
+func TestBannerThreshold(t *testing.T) {
+	b, clock := newTestBanner()
+
+	b.Fail(testAddr)
+	clock.Add(20 * time.Second)
+	b.Fail(testAddr)
+	if b.Banned(testAddr) != 0 {
+		t.Fatal("banned below the threshold")
+	}
+	clock.Add(20 * time.Second)
+	if !b.Fail(testAddr) {
+		t.Fatal("not banned after the third failure within the window")
+	}
+	if got := b.Banned(testAddr); got != time.Minute {
+		t.Errorf("Banned = %s, want %s", got, time.Minute)
+	}
+	if b.Banned("192.0.2.11") != 0 {
+		t.Error("another address is banned too")
+	}
+}
+
+func TestBannerWindowReset(t *testing.T) {
+	b, clock := newTestBanner()
+
+	b.Fail(testAddr)
+	b.Fail(testAddr)
+	clock.Add(time.Minute + time.Second)
+	if b.Fail(testAddr) {
+		t.Fatal("failures older than the window still count")
+	}
+	if b.Fail(testAddr) {
+		t.Fatal("banned after 2 failures within the window")
+	}
+	if !b.Fail(testAddr) {
+		t.Fatal("not banned after 3 failures within the new window")
+	}
+}
+
+func TestBannerBanLength(t *testing.T) {
+	b, clock := newTestBanner()
+
+	if got := failUntilBanned(t, b, testAddr); got != time.Minute {
+		t.Fatalf("first ban lasts %s, want %s", got, time.Minute)
+	}
+	clock.Add(30 * time.Second)
+	if got := b.Banned(testAddr); got != 30*time.Second {
+		t.Errorf("Banned = %s half way, want %s", got, 30*time.Second)
+	}
+	if !b.Fail(testAddr) {
+		t.Error("Fail during a ban reports no ban")
+	}
+	if got := b.Banned(testAddr); got != 30*time.Second {
+		t.Errorf("failing during a ban extended it to %s", got)
+	}
+	clock.Add(30 * time.Second)
+	if got := b.Banned(testAddr); got != 0 {
+		t.Errorf("still banned for %s after the ban ended", got)
+	}
+}
+
+func TestBannerBackoff(t *testing.T) {
+	b, clock := newTestBanner()
+
+	want := []time.Duration{
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		8 * time.Minute,
+	}
+	for i, w := range want {
+		got := failUntilBanned(t, b, testAddr)
+		if got != w {
+			t.Errorf("ban #%d lasts %s, want %s", i+1, got, w)
+		}
+		clock.Add(got)
+	}
+}
+
+func TestBannerBackoffReset(t *testing.T) {
+	b, clock := newTestBanner()
+
+	clock.Add(failUntilBanned(t, b, testAddr))
+	if got := failUntilBanned(t, b, testAddr); got != 2*time.Minute {
+		t.Fatalf("second ban lasts %s, want %s", got, 2*time.Minute)
+	}
+
+	// MaxBanTime after the last failure the history is forgotten
+	clock.Add(b.MaxBanTime + time.Second)
+	if got := failUntilBanned(t, b, testAddr); got != time.Minute {
+		t.Errorf("ban after a quiet MaxBanTime lasts %s, want %s", got, time.Minute)
+	}
+}
+
+func TestBannerSucceed(t *testing.T) {
+	b, clock := newTestBanner()
+
+	b.Fail(testAddr)
+	b.Fail(testAddr)
+	b.Succeed(testAddr)
+	if len(b.List()) != 0 {
+		t.Error("address without bans is still listed after Succeed")
+	}
+	if b.Fail(testAddr) || b.Fail(testAddr) {
+		t.Fatal("failures before Succeed still count")
+	}
+
+	// earlier bans still count towards the backoff
+	b.Succeed(testAddr)
+	clock.Add(failUntilBanned(t, b, testAddr))
+	b.Succeed(testAddr)
+	if got := failUntilBanned(t, b, testAddr); got != 2*time.Minute {
+		t.Errorf("ban after Succeed lasts %s, want %s", got, 2*time.Minute)
+	}
+}
+
+func TestBannerAllow(t *testing.T) {
+	tests := []struct {
+		name   string
+		allow  string
+		addr   string
+		banned bool
+	}{
+		{name: "ip", allow: testAddr, addr: testAddr},
+		{name: "cidr", allow: "192.0.2.0/24", addr: testAddr},
+		{name: "ipv6 cidr", allow: "2001:db8::/32", addr: "2001:db8::1"},
+		{name: "other ip", allow: "192.0.2.11", addr: testAddr, banned: true},
+		{name: "other cidr", allow: "198.51.100.0/24", addr: testAddr, banned: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestBanner()
+			if err := b.Allow(tt.allow); err != nil {
+				t.Fatalf("Allow(%s): %v", tt.allow, err)
+			}
+			for i := 0; i < 2*b.Threshold; i++ {
+				b.Fail(tt.addr)
+			}
+			if got := b.Banned(tt.addr) != 0; got != tt.banned {
+				t.Errorf("banned = %v, want %v", got, tt.banned)
+			}
+		})
+	}
+
+	b, _ := newTestBanner()
+	if err := b.Ban(testAddr, time.Hour); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+	if err := b.Allow(testAddr); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if b.Banned(testAddr) != 0 {
+		t.Error("allowing a banned address does not lift its ban")
+	}
+	if err := b.Allow("not an address"); err != ErrInvalidAddress {
+		t.Errorf("Allow of garbage returned %v, want ErrInvalidAddress", err)
+	}
+}
+
+func TestBannerPersistAllow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+
+	b, _ := newTestBanner()
+	if err := b.Persist(path); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	if err := b.AllowStatic("10.0.0.0/8"); err != nil {
+		t.Fatalf("AllowStatic: %v", err)
+	}
+	if err := b.Allow("192.0.2.0/24"); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if got, want := b.Allowlist(), []string{"10.0.0.0/8", "192.0.2.0/24"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Allowlist = %v, want %v", got, want)
+	}
+
+	reloaded, _ := newTestBanner()
+	if err := reloaded.Persist(path); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got, want := reloaded.Allowlist(), []string{"192.0.2.0/24"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded Allowlist = %v, want %v", got, want)
+	}
+
+	// a saved entry given on the command line again stays saved
+	if err := reloaded.AllowStatic("192.0.2.0/24"); err != nil {
+		t.Fatalf("AllowStatic: %v", err)
+	}
+	if err := reloaded.Ban("198.51.100.1", time.Hour); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+	again, _ := newTestBanner()
+	if err := again.Persist(path); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got, want := again.Allowlist(), []string{"192.0.2.0/24"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded Allowlist = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credentials are the user name and password the server accepts, Password
+// is either plain text or a bcrypt hash as printed by HashPassword
+type Credentials struct {
+	User     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// Check reports whether usr and pwd match c without leaking timing
+// information about the expected values
+func (c Credentials) Check(usr, pwd string) bool {
+	userOK := constantTimeEqual(usr, c.User)
+	var pwdOK bool
+	if IsPasswordHash(c.Password) {
+		pwdOK = bcrypt.CompareHashAndPassword([]byte(c.Password), []byte(pwd)) == nil
+	} else {
+		pwdOK = constantTimeEqual(pwd, c.Password)
+	}
+	return userOK && pwdOK
+}
+
+// HashPassword returns the bcrypt hash of pwd
+func HashPassword(pwd string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// IsPasswordHash reports whether s looks like a bcrypt hash
+func IsPasswordHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// ReadPasswordFile returns the first line of the file at path
+func ReadPasswordFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("read password file err: %w", err)
+	}
+	defer f.Close()
+
+	return ReadPassword(f)
+}
+
+// ReadPassword returns the first line of r without its line ending
+func ReadPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// constantTimeEqual compares the SHA-256 sums of a and b, so neither the
+// content nor the length of b leaks through timing
+func constantTimeEqual(a, b string) bool {
+	sumA := sha256.Sum256([]byte(a))
+	sumB := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(sumA[:], sumB[:]) == 1
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+const (
+	// DeviceCookieName is the name of the cookie holding the token of an approved device
+	DeviceCookieName = "pmfe_device"
+	// DeviceRequestCookieName is the name of the cookie holding the id of a pending request
+	DeviceRequestCookieName = "pmfe_device_request"
+	// DeviceTokenLifetime is how long an approved device stays logged in
+	DeviceTokenLifetime = 365 * 24 * time.Hour
+	// DeviceRequestLifetime is how long a request waits for approval
+	DeviceRequestLifetime = 10 * time.Minute
+
+	maxPendingDevices = 20
+	// maxPendingPerAddr keeps one client from filling the whole queue
+	maxPendingPerAddr = 3
+)
+
+var (
+	// ErrDeviceNotFound is returned for unknown device ids
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrTooManyRequests is returned when too many devices wait for approval
+	ErrTooManyRequests = errors.New("too many pending device requests")
+)
+
+// DeviceStatus is the state of a device access request
+type DeviceStatus string
+
+const (
+	DevicePending  DeviceStatus = "pending"
+	DeviceApproved DeviceStatus = "approved"
+	DeviceDenied   DeviceStatus = "denied"
+)
+
+// Device is a device that asked for access, Code is shown both on the device
+// and on the admin page so the owner approves the right one
+type Device struct {
+	ID          string       `json:"id"`
+	Code        string       `json:"code"`
+	Addr        string       `json:"addr"`
+	UserAgent   string       `json:"user_agent"`
+	User        string       `json:"user,omitempty"`
+	Status      DeviceStatus `json:"status"`
+	RequestedAt time.Time    `json:"requested_at"`
+	ApprovedAt  time.Time    `json:"approved_at"`
+	LastSeen    time.Time    `json:"last_seen"`
+	TokenHash   string       `json:"token_hash,omitempty"`
+
+	// token is handed to the device once, the first time it asks after approval
+	token string
+}
+
+// Devices keeps device access requests and the approved devices
+type Devices struct {
+	devices map[string]*Device
+	path    string
+	mutex   sync.Mutex
+}
+
+// NewDevices creates an empty Devices
+func NewDevices() *Devices {
+	return &Devices{devices: make(map[string]*Device)}
+}
+
+// Persist loads the approved devices saved at path and saves them there
+// after every change
+func (d *Devices) Persist(path string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.path = path
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read devices file err: %w", err)
+	}
+
+	var list []Device
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("decode devices file err: %w", err)
+	}
+	for i := range list {
+		dev := list[i]
+		d.devices[dev.ID] = &dev
+	}
+	return nil
+}
+
+// Request records a pending access request of the device at addr, failing
+// with ErrTooManyRequests when too many requests wait in total or from addr
+func (d *Devices) Request(addr, userAgent string) (Device, error) {
+	id, err := utils.RandomToken(16)
+	if err != nil {
+		return Device{}, err
+	}
+	code, err := randomDigits(4)
+	if err != nil {
+		return Device{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	pending, fromAddr := 0, 0
+	for key, dev := range d.devices {
+		if dev.Status == DeviceApproved {
+			continue
+		}
+		if now.Sub(dev.RequestedAt) > DeviceRequestLifetime {
+			delete(d.devices, key)
+			continue
+		}
+		pending++
+		if dev.Addr == addr {
+			fromAddr++
+		}
+	}
+	if pending >= maxPendingDevices || fromAddr >= maxPendingPerAddr {
+		return Device{}, ErrTooManyRequests
+	}
+
+	dev := &Device{
+		ID:          id,
+		Code:        code,
+		Addr:        addr,
+		UserAgent:   userAgent,
+		Status:      DevicePending,
+		RequestedAt: now,
+	}
+	d.devices[id] = dev
+	return *dev, nil
+}
+
+// Claim returns the request id and, once after its approval, the device token
+func (d *Devices) Claim(id string) (Device, string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	dev, ok := d.devices[id]
+	if !ok || dev.Status != DeviceApproved && time.Since(dev.RequestedAt) > DeviceRequestLifetime {
+		return Device{}, "", ErrDeviceNotFound
+	}
+	token := dev.token
+	dev.token = ""
+	return *dev, token, nil
+}
+
+// Approve lets the device id log in as user
+func (d *Devices) Approve(id, user string) error {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	dev, ok := d.devices[id]
+	if !ok || dev.Status != DevicePending {
+		return ErrDeviceNotFound
+	}
+	dev.Status = DeviceApproved
+	dev.User = user
+	dev.ApprovedAt = time.Now()
+	dev.TokenHash = hashToken(token)
+	dev.token = token
+	return d.save()
+}
+
+// Deny rejects the pending request id
+func (d *Devices) Deny(id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	dev, ok := d.devices[id]
+	if !ok || dev.Status != DevicePending {
+		return ErrDeviceNotFound
+	}
+	dev.Status = DeviceDenied
+	return nil
+}
+
+// Revoke removes the device id, its token stops working
+func (d *Devices) Revoke(id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.devices[id]; !ok {
+		return ErrDeviceNotFound
+	}
+	delete(d.devices, id)
+	return d.save()
+}
+
+// List returns the requests and approved devices, newest first
+func (d *Devices) List() []Device {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	list := make([]Device, 0, len(d.devices))
+	for _, dev := range d.devices {
+		if dev.Status != DeviceApproved && now.Sub(dev.RequestedAt) > DeviceRequestLifetime {
+			continue
+		}
+		list = append(list, *dev)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RequestedAt.After(list[j].RequestedAt)
+	})
+	return list
+}
+
+// Authenticate returns the approved device a token belongs to
+func (d *Devices) Authenticate(token string) (Device, bool) {
+	if token == "" {
+		return Device{}, false
+	}
+	hash := hashToken(token)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	for _, dev := range d.devices {
+		if dev.Status == DeviceApproved && dev.TokenHash == hash && now.Sub(dev.ApprovedAt) < DeviceTokenLifetime {
+			dev.LastSeen = now
+			return *dev, true
+		}
+	}
+	return Device{}, false
+}
+
+// SetTokenCookie stores the device token in the browser of r
+func SetTokenCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     DeviceCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(DeviceTokenLifetime),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// save writes the approved devices to the file given to Persist, if any,
+// the caller holds the lock
+func (d *Devices) save() error {
+	if d.path == "" {
+		return nil
+	}
+
+	list := make([]Device, 0, len(d.devices))
+	for _, dev := range d.devices {
+		if dev.Status == DeviceApproved {
+			list = append(list, *dev)
+		}
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("encode devices file err: %w", err)
+	}
+	if err := utils.WriteFileAtomic(d.path, b, 0600); err != nil {
+		return fmt.Errorf("write devices file err: %w", err)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomDigits returns n uniformly random decimal digits
+func randomDigits(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, v.Int64()), nil
+}
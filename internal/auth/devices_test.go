@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDevicesRequestLimit(t *testing.T) {
+	d := NewDevices()
+	for i := 0; i < maxPendingPerAddr; i++ {
+		if _, err := d.Request("198.51.100.1", "phone"); err != nil {
+			t.Fatalf("request #%d: %v", i+1, err)
+		}
+	}
+	if _, err := d.Request("198.51.100.1", "phone"); err != ErrTooManyRequests {
+		t.Fatalf("request over the per address limit returned %v, want ErrTooManyRequests", err)
+	}
+
+	// other clients still get in until the queue is full
+	for i := maxPendingPerAddr; i < maxPendingDevices; i++ {
+		if _, err := d.Request(fmt.Sprintf("198.51.100.%d", 10+i), "phone"); err != nil {
+			t.Fatalf("request #%d: %v", i+1, err)
+		}
+	}
+	if _, err := d.Request("198.51.100.200", "phone"); err != ErrTooManyRequests {
+		t.Fatalf("request over the total limit returned %v, want ErrTooManyRequests", err)
+	}
+}
+
+func TestRandomDigits(t *testing.T) {
+	const draws = 20000
+	counts := make(map[rune]int)
+	for i := 0; i < draws/4; i++ {
+		code, err := randomDigits(4)
+		if err != nil {
+			t.Fatalf("randomDigits: %v", err)
+		}
+		if len(code) != 4 {
+			t.Fatalf("code %q has %d digits, want 4", code, len(code))
+		}
+		for _, c := range code {
+			if c < '0' || c > '9' {
+				t.Fatalf("code %q holds %q", code, c)
+			}
+			counts[c]++
+		}
+	}
+	// each digit is expected draws/10 times, far from the skew of taking
+	// random characters modulo 10
+	for c := '0'; c <= '9'; c++ {
+		if n := counts[c]; n < draws/10*85/100 || n > draws/10*115/100 {
+			t.Errorf("digit %c drawn %d times of %d", c, n, draws)
+		}
+	}
+}
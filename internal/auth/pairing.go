@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+// pairingToken is a pairing token and the user it logs in as
+type pairingToken struct {
+	user    string
+	expires time.Time
+}
+
+// Pairing hands out short-lived, single-use tokens that log a device in
+type Pairing struct {
+	tokens map[string]pairingToken
+	ttl    time.Duration
+	mutex  sync.Mutex
+}
+
+// NewPairing creates a Pairing whose tokens expire after ttl
+func NewPairing(ttl time.Duration) *Pairing {
+	return &Pairing{
+		tokens: make(map[string]pairingToken),
+		ttl:    ttl,
+	}
+}
+
+// TTL returns how long a token stays valid
+func (p *Pairing) TTL() time.Duration {
+	return p.ttl
+}
+
+// Current returns a token logging in as user, valid for at least half of
+// the ttl, rotating it once it has been used or is about to expire
+func (p *Pairing) Current(user string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for token, t := range p.tokens {
+		if now.After(t.expires) {
+			delete(p.tokens, token)
+			continue
+		}
+		if t.user == user && t.expires.Sub(now) >= p.ttl/2 {
+			return token, nil
+		}
+	}
+
+	token, err := utils.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+	p.tokens[token] = pairingToken{user: user, expires: now.Add(p.ttl)}
+	return token, nil
+}
+
+// Reset invalidates the unused tokens of user, the next Current call
+// issues a fresh one
+func (p *Pairing) Reset(user string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for token, t := range p.tokens {
+		if t.user == user {
+			delete(p.tokens, token)
+		}
+	}
+}
+
+// Consume invalidates token and returns the user it logs in as if it was valid
+func (p *Pairing) Consume(token string) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	t, ok := p.tokens[token]
+	if !ok {
+		return "", false
+	}
+	delete(p.tokens, token)
+	return t.user, time.Now().Before(t.expires)
+}
+
+// PairHandler logs the device in when the request carries a valid pairing
+// token, then redirects it to the path target below its base URI
+func PairHandler(p *Pairing, sessions *SessionStore, target string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := p.Consume(r.URL.Query().Get("token"))
+		if !ok {
+			http.Error(w, "Invalid or expired pairing token", http.StatusForbidden)
+			return
+		}
+
+		if err := sessions.Create(w, r, user); err != nil {
+			http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Device paired as %s: %s %s", user, utils.RemoteIP(r), r.UserAgent())
+		http.Redirect(w, r, utils.RequestBaseURI(r)+target, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPairingReset(t *testing.T) {
+	p := NewPairing(time.Minute)
+	first, err := p.Current("admin")
+	if err != nil {
+		t.Fatalf("Current: %v", err)
+	}
+	if again, _ := p.Current("admin"); again != first {
+		t.Fatal("Current rotated a token with most of its ttl left")
+	}
+	other, _ := p.Current("mom")
+
+	p.Reset("admin")
+	fresh, err := p.Current("admin")
+	if err != nil {
+		t.Fatalf("Current: %v", err)
+	}
+	if fresh == first {
+		t.Error("Current after Reset returned the old token")
+	}
+	if _, ok := p.Consume(first); ok {
+		t.Error("the token from before Reset still logs in")
+	}
+	if user, ok := p.Consume(other); !ok || user != "mom" {
+		t.Error("Reset invalidated the token of another user")
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy decides who may use a route
+type Policy string
+
+const (
+	// PolicyPublic lets everybody in
+	PolicyPublic Policy = "public"
+	// PolicyAuthenticated requires a logged in user
+	PolicyAuthenticated Policy = "authenticated"
+	// PolicyLocalhost only lets the PC itself in, without login, requests must
+	// come from a loopback address or one of the addresses of the PC
+	PolicyLocalhost Policy = "localhost"
+
+	rolePrefix = "role:"
+)
+
+// RolePolicy requires a logged in user with permission p
+func RolePolicy(p Permission) Policy {
+	return Policy(rolePrefix + string(p))
+}
+
+// Permission returns the permission a role policy requires
+func (p Policy) Permission() (Permission, bool) {
+	if !strings.HasPrefix(string(p), rolePrefix) {
+		return "", false
+	}
+	return Permission(strings.TrimPrefix(string(p), rolePrefix)), true
+}
+
+// ParsePolicy parses public, authenticated, localhost or role:<permission>
+func ParsePolicy(s string) (Policy, error) {
+	p := Policy(strings.TrimSpace(s))
+	switch p {
+	case PolicyPublic, PolicyAuthenticated, PolicyLocalhost:
+		return p, nil
+	}
+	if perm, ok := p.Permission(); ok {
+		switch perm {
+		case PermDownload, PermUpload, PermClipboard, PermAdmin:
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown policy %q", s)
+}
+
+// RoutePolicies overrides the default policy of routes, keyed by path prefix
+type RoutePolicies map[string]Policy
+
+// ParseRoutePolicies parses a comma separated list like
+// "/upload=public,/qrcode=localhost,/clipboard=role:admin"
+func ParseRoutePolicies(s string) (RoutePolicies, error) {
+	rp := make(RoutePolicies)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		idx := strings.Index(item, "=")
+		if idx == -1 || !strings.HasPrefix(item, "/") {
+			return nil, fmt.Errorf("invalid route policy %q, want /path=policy", item)
+		}
+		p, err := ParsePolicy(item[idx+1:])
+		if err != nil {
+			return nil, err
+		}
+		rp[strings.TrimSuffix(item[:idx], "/")] = p
+	}
+	return rp, nil
+}
+
+// Lookup returns the policy of the longest prefix matching pattern as whole
+// path elements, def when there is none
+func (rp RoutePolicies) Lookup(pattern string, def Policy) Policy {
+	best := -1
+	policy := def
+	for prefix, p := range rp {
+		if pattern != prefix && pattern != prefix+"/" && !strings.HasPrefix(pattern, prefix+"/") {
+			continue
+		}
+		if len(prefix) > best {
+			best = len(prefix)
+			policy = p
+		}
+	}
+	return policy
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Policy
+		wantErr bool
+	}{
+		{in: "public", want: PolicyPublic},
+		{in: " authenticated ", want: PolicyAuthenticated},
+		{in: "localhost", want: PolicyLocalhost},
+		{in: "role:upload", want: RolePolicy(PermUpload)},
+		{in: "role:admin", want: RolePolicy(PermAdmin)},
+		{in: "role:root", wantErr: true},
+		{in: "everybody", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseRoutePolicies(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    RoutePolicies
+		wantErr bool
+	}{
+		{spec: "", want: RoutePolicies{}},
+		{
+			spec: "/upload=public, /qrcode/=localhost,,/clipboard=role:admin",
+			want: RoutePolicies{
+				"/upload":    PolicyPublic,
+				"/qrcode":    PolicyLocalhost,
+				"/clipboard": RolePolicy(PermAdmin),
+			},
+		},
+		{spec: "upload=public", wantErr: true},
+		{spec: "/upload", wantErr: true},
+		{spec: "/upload=nobody", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRoutePolicies(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRoutePolicies(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRoutePolicies(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestRoutePoliciesLookup(t *testing.T) {
+	rp, err := ParseRoutePolicies("/qrcode=localhost,/clipboard=role:admin,/clipboard/host=public,/device=localhost")
+	if err != nil {
+		t.Fatalf("ParseRoutePolicies: %v", err)
+	}
+
+	def := RolePolicy(PermDownload)
+	tests := []struct {
+		pattern string
+		want    Policy
+	}{
+		{"/qrcode", PolicyLocalhost},
+		{"/qrcode/", PolicyLocalhost},
+		{"/qrcode/png", PolicyLocalhost},
+		{"/qrcode/file/", PolicyLocalhost},
+		{"/clipboard/generate", RolePolicy(PermAdmin)},
+		// the longest prefix wins
+		{"/clipboard/host", PolicyPublic},
+		{"/clipboard/hosts", RolePolicy(PermAdmin)},
+		// prefixes match whole path elements only
+		{"/qrcodes", def},
+		{"/devices", def},
+		{"/device", PolicyLocalhost},
+		{"/upload", def},
+	}
+	for _, tt := range tests {
+		if got := rp.Lookup(tt.pattern, def); got != tt.want {
+			t.Errorf("Lookup(%s) = %s, want %s", tt.pattern, got, tt.want)
+		}
+	}
+}
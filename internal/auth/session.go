@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+const (
+	// SessionCookieName is the name of the cookie holding the session id
+	SessionCookieName = "pmfe_session"
+	// DefaultSessionLifetime is used when NewSessionStore gets a non-positive lifetime
+	DefaultSessionLifetime = 24 * time.Hour
+	// CSRFHeader is the request header carrying the CSRF token of the session
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField is the form field or query parameter carrying the CSRF token
+	CSRFField = "csrf_token"
+)
+
+type contextKey int
+
+const (
+	csrfContextKey contextKey = iota
+	userContextKey
+)
+
+// session is a logged in user and the end of its login, device is the id of
+// the approved device the session was created for, if any
+type session struct {
+	user    string
+	device  string
+	expires time.Time
+}
+
+// SessionStore keeps logged in sessions identified by a signed cookie value
+type SessionStore struct {
+	// LoginURL is the path of the login page below the base URI browsers
+	// without a session are sent to, empty disables the redirect
+	LoginURL string
+	sessions map[string]session
+	lifetime time.Duration
+	secret   []byte
+	mutex    sync.Mutex
+}
+
+// NewSessionStore creates a SessionStore whose sessions last for lifetime
+func NewSessionStore(lifetime time.Duration, loginURL string) (*SessionStore, error) {
+	if lifetime <= 0 {
+		lifetime = DefaultSessionLifetime
+	}
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionStore{
+		LoginURL: loginURL,
+		sessions: make(map[string]session),
+		lifetime: lifetime,
+		secret:   []byte(secret),
+	}, nil
+}
+
+// Lifetime returns how long a session lasts
+func (s *SessionStore) Lifetime() time.Duration {
+	return s.lifetime
+}
+
+// Create starts a new session for user and sets its cookie on w
+func (s *SessionStore) Create(w http.ResponseWriter, r *http.Request, user string) error {
+	return s.CreateForDevice(w, r, user, "")
+}
+
+// CreateForDevice starts a new session for user like Create, the session
+// ends when DestroyDevice is called for device
+func (s *SessionStore) CreateForDevice(w http.ResponseWriter, r *http.Request, user, device string) error {
+	id, err := utils.RandomToken(24)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expires := now.Add(s.lifetime)
+	s.mutex.Lock()
+	// sessions that are never used again are only dropped here
+	for key, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, key)
+		}
+	}
+	s.sessions[id] = session{user: user, device: device, expires: expires}
+	s.mutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    id + "." + s.sign("session:"+id),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Destroy ends the session of r, if any, and clears its cookie
+func (s *SessionStore) Destroy(w http.ResponseWriter, r *http.Request) {
+	if id, ok := s.sessionID(r); ok {
+		s.mutex.Lock()
+		delete(s.sessions, id)
+		s.mutex.Unlock()
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// DestroyDevice ends every session created for device
+func (s *SessionStore) DestroyDevice(device string) {
+	if device == "" {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, sess := range s.sessions {
+		if sess.device == device {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// User returns the user of the live session r carries a cookie of
+func (s *SessionStore) User(r *http.Request) (string, bool) {
+	id, ok := s.sessionID(r)
+	if !ok {
+		return "", false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(sess.expires) {
+		delete(s.sessions, id)
+		return "", false
+	}
+	return sess.user, true
+}
+
+// CheckCSRF reports whether r carries the CSRF token of its session,
+// requests with a safe method always pass
+func (s *SessionStore) CheckCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	id, ok := s.sessionID(r)
+	if !ok {
+		return false
+	}
+
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.URL.Query().Get(CSRFField)
+	}
+	if token == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		token = r.PostFormValue(CSRFField)
+	}
+	return hmac.Equal([]byte(token), []byte(s.csrfToken(id)))
+}
+
+// WithCSRFToken stores the CSRF token of the session of r in its context
+func (s *SessionStore) WithCSRFToken(r *http.Request) *http.Request {
+	id, ok := s.sessionID(r)
+	if !ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), csrfContextKey, s.csrfToken(id)))
+}
+
+// CSRFToken returns the token pages must send back with POST requests,
+// empty when the request is not authenticated by a session
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey).(string)
+	return token
+}
+
+// sessionID returns the id from the session cookie of r if its signature is valid
+func (s *SessionStore) sessionID(r *http.Request) (string, bool) {
+	c, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return "", false
+	}
+
+	idx := strings.LastIndex(c.Value, ".")
+	if idx == -1 {
+		return "", false
+	}
+	id := c.Value[:idx]
+	if !hmac.Equal([]byte(c.Value[idx+1:]), []byte(s.sign("session:"+id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func (s *SessionStore) csrfToken(id string) string {
+	return s.sign("csrf:" + id)
+}
+
+func (s *SessionStore) sign(value string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// Permission grants access to a part of the server
+type Permission string
+
+const (
+	// PermDownload allows browsing and downloading the shared tree
+	PermDownload Permission = "download"
+	// PermUpload allows uploading files
+	PermUpload Permission = "upload"
+	// PermClipboard allows using the clipboard
+	PermClipboard Permission = "clipboard"
+	// PermAdmin allows everything, including the ban list
+	PermAdmin Permission = "admin"
+)
+
+// User is an account with its permissions and directories, an empty Root or
+// UploadDir means the directories given on the command line
+type User struct {
+	Credentials
+	Permissions []Permission `json:"permissions"`
+	Root        string       `json:"root,omitempty"`
+	UploadDir   string       `json:"upload_dir,omitempty"`
+}
+
+// Can reports whether u has permission p, admins can do everything
+func (u *User) Can(p Permission) bool {
+	for _, perm := range u.Permissions {
+		if perm == p || perm == PermAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Users holds the accounts allowed to log in
+type Users struct {
+	users map[string]*User
+	owner *User
+}
+
+// NewUsers checks list and creates Users from it, the first admin becomes the owner
+func NewUsers(list []User) (*Users, error) {
+	us := &Users{users: make(map[string]*User, len(list))}
+	for i := range list {
+		u := &list[i]
+		if u.User == "" || u.Password == "" {
+			return nil, fmt.Errorf("user #%d: name and password are required", i+1)
+		}
+		if _, ok := us.users[u.User]; ok {
+			return nil, fmt.Errorf("user %s: defined twice", u.User)
+		}
+		for _, p := range u.Permissions {
+			switch p {
+			case PermDownload, PermUpload, PermClipboard, PermAdmin:
+			default:
+				return nil, fmt.Errorf("user %s: unknown permission %q", u.User, p)
+			}
+		}
+		if us.owner == nil && u.Can(PermAdmin) {
+			us.owner = u
+		}
+		us.users[u.User] = u
+	}
+	if us.owner == nil {
+		return nil, fmt.Errorf("at least one user needs the %s permission", PermAdmin)
+	}
+	return us, nil
+}
+
+// LoadUsers reads a JSON array of users from path
+func LoadUsers(path string) (*Users, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read users file err: %w", err)
+	}
+
+	var list []User
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("decode users file err: %w", err)
+	}
+	return NewUsers(list)
+}
+
+// Get returns the user called name
+func (us *Users) Get(name string) (*User, bool) {
+	u, ok := us.users[name]
+	return u, ok
+}
+
+// Names returns the user names, sorted
+func (us *Users) Names() []string {
+	names := make([]string, 0, len(us.users))
+	for name := range us.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Owner returns the first admin, the account the PC owner logs in with
+func (us *Users) Owner() *User {
+	return us.owner
+}
+
+// Authenticate returns the user called name if pwd is its password
+func (us *Users) Authenticate(name, pwd string) (*User, bool) {
+	u, ok := us.users[name]
+	if !ok {
+		// spend the same time as for a wrong password
+		us.owner.Check("", pwd)
+		return nil, false
+	}
+	if !u.Check(name, pwd) {
+		return nil, false
+	}
+	return u, true
+}
+
+// WithUser stores u in the context of r
+func WithUser(r *http.Request, u *User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, u))
+}
+
+// UserFromRequest returns the user r was authenticated as, nil when
+// authentication is disabled
+func UserFromRequest(r *http.Request) *User {
+	u, _ := r.Context().Value(userContextKey).(*User)
+	return u
+}
+
+// Allowed reports whether the user of r has permission p, everything is
+// allowed when authentication is disabled
+func Allowed(r *http.Request, p Permission) bool {
+	u := UserFromRequest(r)
+	return u == nil || u.Can(p)
+}
@@ -0,0 +1,98 @@
+package clipboard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ErrNoClipboardTool is returned when no supported clipboard command is installed
+var ErrNoClipboardTool = errors.New("no clipboard tool found, install wl-clipboard, xclip or xsel")
+
+// Bridge reads and writes the clipboard of the machine running the server
+type Bridge interface {
+	Read() (string, error)
+	Write(text string) error
+}
+
+// CommandBridge talks to the desktop clipboard through external commands
+type CommandBridge struct {
+	ReadCmd  []string
+	WriteCmd []string
+	Timeout  time.Duration
+}
+
+// clipboardTools lists the supported commands, in order of preference
+var clipboardTools = []struct {
+	wayland  bool
+	goos     string
+	readCmd  []string
+	writeCmd []string
+}{
+	{wayland: true, readCmd: []string{"wl-paste", "--no-newline"}, writeCmd: []string{"wl-copy"}},
+	{readCmd: []string{"xclip", "-selection", "clipboard", "-o"}, writeCmd: []string{"xclip", "-selection", "clipboard", "-i"}},
+	{readCmd: []string{"xsel", "--clipboard", "--output"}, writeCmd: []string{"xsel", "--clipboard", "--input"}},
+	{goos: "darwin", readCmd: []string{"pbpaste"}, writeCmd: []string{"pbcopy"}},
+}
+
+// DetectBridge returns a CommandBridge for the first clipboard tool
+// installed, wl-clipboard is only used inside a Wayland session
+func DetectBridge() (*CommandBridge, error) {
+	wayland := os.Getenv("WAYLAND_DISPLAY") != ""
+	for _, tool := range clipboardTools {
+		if tool.wayland && !wayland {
+			continue
+		}
+		if tool.goos != "" && tool.goos != runtime.GOOS {
+			continue
+		}
+		if _, err := exec.LookPath(tool.readCmd[0]); err != nil {
+			continue
+		}
+		if _, err := exec.LookPath(tool.writeCmd[0]); err != nil {
+			continue
+		}
+		return &CommandBridge{
+			ReadCmd:  tool.readCmd,
+			WriteCmd: tool.writeCmd,
+			Timeout:  3 * time.Second,
+		}, nil
+	}
+	return nil, ErrNoClipboardTool
+}
+
+// Read implements Bridge
+func (b *CommandBridge) Read() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.Timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, b.ReadCmd[0], b.ReadCmd[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s err: %w: %s", b.ReadCmd[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// Write implements Bridge
+func (b *CommandBridge) Write(text string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.Timeout)
+	defer cancel()
+
+	// xclip and wl-copy fork a child serving the selection, its output is
+	// not captured or Wait would block until the selection changes
+	cmd := exec.CommandContext(ctx, b.WriteCmd[0], b.WriteCmd[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s err: %w", b.WriteCmd[0], err)
+	}
+	return nil
+}
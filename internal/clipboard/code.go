@@ -0,0 +1,98 @@
+package clipboard
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultAlphabet leaves out characters that are easily confused, like l, 1, O and 0
+const DefaultAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	adjectives = strings.Fields(`
+		amber angry bold brave bright brisk calm clever cold cool cosy crisp
+		curly dark deep eager early fair fancy fast fierce fluffy fresh gentle
+		giant glad golden grand green happy hidden humble icy jolly kind lazy
+		little lively loud lucky magic merry mighty misty modest noble odd
+		orange pink plain polite proud purple quick quiet rapid red rich
+		round royal rusty shiny shy silent silver simple sleepy slow small
+		smooth snowy soft solid spicy steady stormy sunny sweet swift tall
+		tidy tiny warm wild windy wise witty young zesty`)
+	nouns = strings.Fields(`
+		apple badger banana bear bee bird bison camel carrot cat cedar cherry
+		cloud comet cookie crab crane deer dolphin dragon duck eagle falcon
+		fern fig finch fox frog gecko goat goose grape hawk hedgehog heron
+		horse koala lemon leopard lion lizard llama lotus mango maple melon
+		mole moon moose mouse newt olive otter owl panda panther parrot peach
+		pear pepper pine plum pony puffin rabbit raven river robin rocket
+		salmon seal shark sheep snail sparrow spider squid star swan tiger
+		toad tulip turtle walrus whale wolf yak zebra`)
+)
+
+// ErrAlphabetTooShort is returned when an alphabet has fewer than two characters
+var ErrAlphabetTooShort = errors.New("code alphabet needs at least two characters")
+
+// CodeGenerator creates random clipboard codes from crypto/rand
+type CodeGenerator struct {
+	// Length is the number of characters of a code
+	Length int
+	// Alphabet holds the characters codes are made of
+	Alphabet string
+	// Words switches to codes easy to read aloud, like blue-tiger-42
+	Words bool
+}
+
+// Generate returns a new random code. Each unit of extra makes the code
+// space larger, callers raise it after repeated collisions.
+func (g CodeGenerator) Generate(extra int) (string, error) {
+	if g.Words {
+		return g.words(extra)
+	}
+
+	alphabet := []rune(g.Alphabet)
+	if len(alphabet) < 2 {
+		return "", ErrAlphabetTooShort
+	}
+
+	b := make([]rune, g.Length+extra)
+	for i := range b {
+		n, err := randomInt(len(alphabet))
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[n]
+	}
+	return string(b), nil
+}
+
+func (g CodeGenerator) words(extra int) (string, error) {
+	adjective, err := randomInt(len(adjectives))
+	if err != nil {
+		return "", err
+	}
+	noun, err := randomInt(len(nouns))
+	if err != nil {
+		return "", err
+	}
+
+	limit := 100
+	for i := 0; i < extra; i++ {
+		limit *= 10
+	}
+	number, err := randomInt(limit)
+	if err != nil {
+		return "", err
+	}
+	return adjectives[adjective] + "-" + nouns[noun] + "-" + strconv.Itoa(number), nil
+}
+
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
@@ -0,0 +1,127 @@
+package clipboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+// FileStore is a MemoryStore persisted to a JSON file after every change
+type FileStore struct {
+	*MemoryStore
+	path string
+}
+
+// NewFileStore creates a FileStore backed by path, loading the entries
+// already saved there
+func NewFileStore(path string, maxEntries int) (*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(maxEntries),
+		path:        path,
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read clipboard store err: %w", err)
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("decode clipboard store err: %w", err)
+	}
+	for _, e := range entries {
+		s.MemoryStore.put(e)
+	}
+	s.MemoryStore.sweep(time.Now())
+	return s, nil
+}
+
+// Put implements Store
+func (s *FileStore) Put(e Entry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.put(e)
+	return s.save()
+}
+
+// Consume implements Store
+func (s *FileStore) Consume(code string) (Entry, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.consume(code)
+	if !ok || e.MaxReads == 0 {
+		// the read count of unlimited entries is not worth a rewrite
+		return e, ok, nil
+	}
+	return e, true, s.save()
+}
+
+// Insert implements Store
+func (s *FileStore) Insert(e Entry, dedupe bool) (Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, err := s.insert(e, dedupe)
+	if err != nil || stored.Code != e.Code {
+		return stored, err
+	}
+	return stored, s.save()
+}
+
+// Update implements Store
+func (s *FileStore) Update(code string, fn func(*Entry) error) (Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, err := s.update(code, fn)
+	if err != nil {
+		return e, err
+	}
+	return e, s.save()
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.remove(code)
+	return s.save()
+}
+
+// Sweep implements Store
+func (s *FileStore) Sweep(now time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := s.sweep(now)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.save()
+}
+
+// save writes all entries to the store file, the caller holds the lock
+func (s *FileStore) save() error {
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("encode clipboard store err: %w", err)
+	}
+
+	if err := utils.WriteFileAtomic(s.path, b, 0600); err != nil {
+		return fmt.Errorf("write clipboard store err: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package clipboard
+
+import (
+	"sync"
+	"time"
+)
+
+// Message is a text posted to the live clipboard
+type Message struct {
+	ID   int64     `json:"id"`
+	Text string    `json:"text"`
+	From string    `json:"from"`
+	Time time.Time `json:"time"`
+}
+
+// Hub fans live clipboard messages out to every subscriber and keeps a
+// short history for devices connecting later
+type Hub struct {
+	subscribers map[chan Message]struct{}
+	history     []Message
+	historySize int
+	nextID      int64
+	mutex       sync.Mutex
+}
+
+// NewHub creates a Hub remembering the last historySize messages
+func NewHub(historySize int) *Hub {
+	return &Hub{
+		subscribers: make(map[chan Message]struct{}),
+		historySize: historySize,
+	}
+}
+
+// Publish sends text to every subscriber
+func (h *Hub) Publish(text, from string) Message {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.nextID++
+	msg := Message{
+		ID:   h.nextID,
+		Text: text,
+		From: from,
+		Time: time.Now(),
+	}
+
+	h.history = append(h.history, msg)
+	if len(h.history) > h.historySize {
+		h.history = h.history[len(h.history)-h.historySize:]
+	}
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			// the subscriber is too slow, it still gets the history on reconnect
+		}
+	}
+	return msg
+}
+
+// Subscribe returns a channel receiving new messages, the history so far and
+// a function to call once the subscriber goes away
+func (h *Hub) Subscribe() (<-chan Message, []Message, func()) {
+	ch := make(chan Message, 16)
+
+	h.mutex.Lock()
+	h.subscribers[ch] = struct{}{}
+	history := make([]Message, len(h.history))
+	copy(history, h.history)
+	h.mutex.Unlock()
+
+	cancel := func() {
+		h.mutex.Lock()
+		delete(h.subscribers, ch)
+		h.mutex.Unlock()
+	}
+	return ch, history, cancel
+}
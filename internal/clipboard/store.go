@@ -0,0 +1,286 @@
+package clipboard
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCodeExists is returned by Insert when the code is already taken
+	ErrCodeExists = errors.New("clipboard code already exists")
+	// ErrNotFound is returned by Update when there is no live entry for the code
+	ErrNotFound = errors.New("clipboard entry not found")
+)
+
+// Attachment is a file carried by a clipboard entry
+type Attachment struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// Entry is a piece of content shared through the clipboard
+type Entry struct {
+	Code string `json:"code"`
+	// Owner is the user who created the entry, empty without logins
+	Owner      string      `json:"owner,omitempty"`
+	Content    string      `json:"content"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+	// Encrypted marks content encrypted in the browser, the server never sees the passphrase
+	Encrypted bool      `json:"encrypted"`
+	Finger    uint64    `json:"finger"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// MaxReads removes the entry after that many retrievals, 0 means no limit
+	MaxReads int `json:"max_reads"`
+	Reads    int `json:"reads"`
+}
+
+// Expired reports whether the entry is no longer valid at t, a zero
+// ExpiresAt never expires
+func (e Entry) Expired(t time.Time) bool {
+	return !e.ExpiresAt.IsZero() && t.After(e.ExpiresAt)
+}
+
+// Shareable reports whether the entry may be handed out again for identical
+// content, entries with options are never shared
+func (e Entry) Shareable() bool {
+	return e.MaxReads == 0 && !e.Encrypted
+}
+
+// SameContent reports whether e and o hold the same text and attachment,
+// the fingerprint alone may collide
+func (e Entry) SameContent(o Entry) bool {
+	if e.Content != o.Content {
+		return false
+	}
+	if e.Attachment == nil || o.Attachment == nil {
+		return e.Attachment == nil && o.Attachment == nil
+	}
+	return e.Attachment.Name == o.Attachment.Name &&
+		e.Attachment.Type == o.Attachment.Type &&
+		bytes.Equal(e.Attachment.Data, o.Attachment.Data)
+}
+
+// Store keeps clipboard entries by code
+type Store interface {
+	// Get returns the live entry for code
+	Get(code string) (Entry, bool)
+	// Consume returns the live entry for code and counts one retrieval,
+	// removing the entry when it reached MaxReads
+	Consume(code string) (Entry, bool, error)
+	// Put adds or replaces an entry, evicting the oldest ones when full
+	Put(e Entry) error
+	// Insert adds an entry like Put, failing with ErrCodeExists when a live
+	// entry already uses its code. With dedupe set and e shareable, a live
+	// shareable entry of the same owner holding the same content is returned
+	// instead and nothing is added. Lookup and insert happen atomically.
+	Insert(e Entry, dedupe bool) (Entry, error)
+	// Update changes the live entry for code with fn and returns the result,
+	// nothing is stored when fn fails. Lookup and change happen atomically.
+	Update(code string, fn func(*Entry) error) (Entry, error)
+	// Delete removes the entry for code
+	Delete(code string) error
+	// List returns the live entries, newest first
+	List() []Entry
+	// Sweep removes the entries expired at now and returns how many were removed
+	Sweep(now time.Time) (int, error)
+}
+
+// MemoryStore is a Store living in memory only. Entries are indexed by the
+// fingerprint of their content to find duplicates quickly.
+type MemoryStore struct {
+	entries    map[string]Entry
+	fingers    map[uint64][]string
+	maxEntries int
+	mutex      sync.RWMutex
+}
+
+// NewMemoryStore creates a MemoryStore holding at most maxEntries entries,
+// maxEntries <= 0 means no limit
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		entries:    make(map[string]Entry),
+		fingers:    make(map[uint64][]string),
+		maxEntries: maxEntries,
+	}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(code string) (Entry, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	e, ok := s.entries[code]
+	if !ok || e.Expired(time.Now()) {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Consume implements Store
+func (s *MemoryStore) Consume(code string) (Entry, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.consume(code)
+	return e, ok, nil
+}
+
+func (s *MemoryStore) consume(code string) (Entry, bool) {
+	e, ok := s.entries[code]
+	if !ok || e.Expired(time.Now()) {
+		return Entry{}, false
+	}
+
+	e.Reads++
+	if e.MaxReads > 0 && e.Reads >= e.MaxReads {
+		s.remove(code)
+	} else {
+		s.entries[code] = e
+	}
+	return e, true
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(e Entry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.put(e)
+	return nil
+}
+
+// Insert implements Store
+func (s *MemoryStore) Insert(e Entry, dedupe bool) (Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.insert(e, dedupe)
+}
+
+func (s *MemoryStore) insert(e Entry, dedupe bool) (Entry, error) {
+	now := time.Now()
+	if dedupe && e.Shareable() {
+		for _, code := range s.fingers[e.Finger] {
+			old := s.entries[code]
+			if !old.Expired(now) && old.Shareable() && old.Owner == e.Owner && old.SameContent(e) {
+				return old, nil
+			}
+		}
+	}
+
+	if old, ok := s.entries[e.Code]; ok && !old.Expired(now) {
+		return Entry{}, ErrCodeExists
+	}
+	s.put(e)
+	return e, nil
+}
+
+func (s *MemoryStore) put(e Entry) {
+	s.remove(e.Code)
+	s.entries[e.Code] = e
+	s.fingers[e.Finger] = append(s.fingers[e.Finger], e.Code)
+
+	for s.maxEntries > 0 && len(s.entries) > s.maxEntries {
+		oldest := ""
+		for code, entry := range s.entries {
+			if oldest == "" || entry.CreatedAt.Before(s.entries[oldest].CreatedAt) {
+				oldest = code
+			}
+		}
+		s.remove(oldest)
+	}
+}
+
+// Update implements Store
+func (s *MemoryStore) Update(code string, fn func(*Entry) error) (Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.update(code, fn)
+}
+
+func (s *MemoryStore) update(code string, fn func(*Entry) error) (Entry, error) {
+	e, ok := s.entries[code]
+	if !ok || e.Expired(time.Now()) {
+		return Entry{}, ErrNotFound
+	}
+	if err := fn(&e); err != nil {
+		return Entry{}, err
+	}
+	e.Code = code
+	s.put(e)
+	return e, nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.remove(code)
+	return nil
+}
+
+// remove deletes code and its fingerprint, the caller holds the lock
+func (s *MemoryStore) remove(code string) {
+	e, ok := s.entries[code]
+	if !ok {
+		return
+	}
+	delete(s.entries, code)
+
+	codes := s.fingers[e.Finger]
+	for i, c := range codes {
+		if c == code {
+			codes = append(codes[:i], codes[i+1:]...)
+			break
+		}
+	}
+	if len(codes) == 0 {
+		delete(s.fingers, e.Finger)
+	} else {
+		s.fingers[e.Finger] = codes
+	}
+}
+
+// List implements Store
+func (s *MemoryStore) List() []Entry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		if !e.Expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+// Sweep implements Store
+func (s *MemoryStore) Sweep(now time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.sweep(now), nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) int {
+	n := 0
+	for code, e := range s.entries {
+		if e.Expired(now) {
+			s.remove(code)
+			n++
+		}
+	}
+	return n
+}
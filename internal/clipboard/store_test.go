@@ -0,0 +1,348 @@
+package clipboard
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stores returns a fresh MemoryStore and FileStore holding at most maxEntries
+func stores(t *testing.T, maxEntries int) map[string]Store {
+	t.Helper()
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "clipboard.json"), maxEntries)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	return map[string]Store{
+		"memory": NewMemoryStore(maxEntries),
+		"file":   fs,
+	}
+}
+
+// memory returns the MemoryStore behind s
+func memory(s Store) *MemoryStore {
+	if fs, ok := s.(*FileStore); ok {
+		return fs.MemoryStore
+	}
+	return s.(*MemoryStore)
+}
+
+// checkFingers fails unless the fingerprint index lists exactly the stored codes
+func checkFingers(t *testing.T, s Store) {
+	t.Helper()
+	m := memory(s)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	indexed := 0
+	for finger, codes := range m.fingers {
+		if len(codes) == 0 {
+			t.Errorf("finger %d has no codes left", finger)
+		}
+		for _, code := range codes {
+			e, ok := m.entries[code]
+			if !ok {
+				t.Errorf("finger %d lists removed code %s", finger, code)
+			} else if e.Finger != finger {
+				t.Errorf("finger %d lists code %s of finger %d", finger, code, e.Finger)
+			}
+			indexed++
+		}
+	}
+	if indexed != len(m.entries) {
+		t.Errorf("fingers index %d codes, want %d", indexed, len(m.entries))
+	}
+}
+
+func TestInsertDedupe(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		old      Entry
+		entry    Entry
+		dedupe   bool
+		wantCode string
+	}{
+		{
+			name:     "same content",
+			old:      Entry{Code: "a", Content: "hello", Finger: 1, CreatedAt: now},
+			entry:    Entry{Code: "b", Content: "hello", Finger: 1, CreatedAt: now},
+			dedupe:   true,
+			wantCode: "a",
+		},
+		{
+			name:     "finger collision",
+			old:      Entry{Code: "a", Content: "hello", Finger: 1, CreatedAt: now},
+			entry:    Entry{Code: "b", Content: "world", Finger: 1, CreatedAt: now},
+			dedupe:   true,
+			wantCode: "b",
+		},
+		{
+			name:     "attachment collision",
+			old:      Entry{Code: "a", Attachment: &Attachment{Name: "a.txt", Data: []byte("1")}, Finger: 1, CreatedAt: now},
+			entry:    Entry{Code: "b", Attachment: &Attachment{Name: "a.txt", Data: []byte("2")}, Finger: 1, CreatedAt: now},
+			dedupe:   true,
+			wantCode: "b",
+		},
+		{
+			name:     "no dedupe",
+			old:      Entry{Code: "a", Content: "hello", Finger: 1, CreatedAt: now},
+			entry:    Entry{Code: "b", Content: "hello", Finger: 1, CreatedAt: now},
+			wantCode: "b",
+		},
+		{
+			name:     "read limited",
+			old:      Entry{Code: "a", Content: "hello", Finger: 1, CreatedAt: now},
+			entry:    Entry{Code: "b", Content: "hello", Finger: 1, CreatedAt: now, MaxReads: 1},
+			dedupe:   true,
+			wantCode: "b",
+		},
+		{
+			name:     "other owner",
+			old:      Entry{Code: "a", Owner: "mom", Content: "hello", Finger: 1, CreatedAt: now},
+			entry:    Entry{Code: "b", Owner: "kid", Content: "hello", Finger: 1, CreatedAt: now},
+			dedupe:   true,
+			wantCode: "b",
+		},
+		{
+			name:     "expired",
+			old:      Entry{Code: "a", Content: "hello", Finger: 1, CreatedAt: now, ExpiresAt: now.Add(-time.Minute)},
+			entry:    Entry{Code: "b", Content: "hello", Finger: 1, CreatedAt: now},
+			dedupe:   true,
+			wantCode: "b",
+		},
+	}
+
+	for _, tt := range tests {
+		for kind, s := range stores(t, 0) {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				if err := s.Put(tt.old); err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+				got, err := s.Insert(tt.entry, tt.dedupe)
+				if err != nil {
+					t.Fatalf("Insert: %v", err)
+				}
+				if got.Code != tt.wantCode {
+					t.Errorf("Insert returned code %s, want %s", got.Code, tt.wantCode)
+				}
+				if _, ok := s.Get(tt.wantCode); !ok {
+					t.Errorf("code %s not stored", tt.wantCode)
+				}
+				checkFingers(t, s)
+			})
+		}
+	}
+}
+
+func TestInsertCodeExists(t *testing.T) {
+	now := time.Now()
+	for kind, s := range stores(t, 0) {
+		t.Run(kind, func(t *testing.T) {
+			if err := s.Put(Entry{Code: "a", Content: "old", Finger: 1, CreatedAt: now}); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if _, err := s.Insert(Entry{Code: "a", Content: "new", Finger: 2, CreatedAt: now}, false); err != ErrCodeExists {
+				t.Fatalf("Insert over a live code returned %v, want ErrCodeExists", err)
+			}
+			if e, _ := s.Get("a"); e.Content != "old" {
+				t.Errorf("content %q was replaced", e.Content)
+			}
+
+			if err := s.Put(Entry{Code: "b", Finger: 3, CreatedAt: now, ExpiresAt: now.Add(-time.Minute)}); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if _, err := s.Insert(Entry{Code: "b", Content: "new", Finger: 4, CreatedAt: now}, false); err != nil {
+				t.Errorf("Insert over an expired code: %v", err)
+			}
+			checkFingers(t, s)
+		})
+	}
+}
+
+func TestFingersCleanup(t *testing.T) {
+	now := time.Now()
+	for kind, s := range stores(t, 2) {
+		t.Run(kind, func(t *testing.T) {
+			put := func(e Entry) {
+				t.Helper()
+				if err := s.Put(e); err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+				checkFingers(t, s)
+			}
+
+			put(Entry{Code: "a", Finger: 1, CreatedAt: now})
+			put(Entry{Code: "b", Finger: 1, CreatedAt: now.Add(time.Second), ExpiresAt: now.Add(time.Minute)})
+			// replacing a code moves it to its new finger
+			put(Entry{Code: "a", Finger: 2, CreatedAt: now})
+
+			if err := s.Delete("a"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			checkFingers(t, s)
+
+			if n, err := s.Sweep(now.Add(time.Hour)); err != nil || n != 1 {
+				t.Fatalf("Sweep = %d, %v, want 1", n, err)
+			}
+			checkFingers(t, s)
+
+			// the third entry evicts the oldest one
+			put(Entry{Code: "c", Finger: 3, CreatedAt: now})
+			put(Entry{Code: "d", Finger: 3, CreatedAt: now.Add(time.Second)})
+			put(Entry{Code: "e", Finger: 4, CreatedAt: now.Add(2 * time.Second)})
+			if _, ok := s.Get("c"); ok {
+				t.Error("oldest entry c was not evicted")
+			}
+
+			if _, _, err := s.Consume("d"); err != nil {
+				t.Fatalf("Consume: %v", err)
+			}
+			put(Entry{Code: "f", Finger: 5, CreatedAt: now.Add(3 * time.Second), MaxReads: 1})
+			if _, _, err := s.Consume("f"); err != nil {
+				t.Fatalf("Consume: %v", err)
+			}
+			if _, ok := s.Get("f"); ok {
+				t.Error("entry f outlived its read limit")
+			}
+			checkFingers(t, s)
+
+			if m := memory(s); len(m.fingers) != 1 {
+				t.Errorf("fingers holds %d fingerprints, want 1", len(m.fingers))
+			}
+		})
+	}
+}
+
+func TestInsertConcurrent(t *testing.T) {
+	const workers = 50
+	now := time.Now()
+	for kind, s := range stores(t, 0) {
+		t.Run(kind, func(t *testing.T) {
+			codes := make([]string, workers)
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					e, err := s.Insert(Entry{
+						Code:      fmt.Sprintf("c%d", i),
+						Content:   "same",
+						Finger:    1,
+						CreatedAt: now,
+					}, true)
+					if err != nil {
+						t.Errorf("Insert: %v", err)
+					}
+					codes[i] = e.Code
+				}(i)
+			}
+			wg.Wait()
+
+			for _, code := range codes {
+				if code != codes[0] {
+					t.Fatalf("identical content got codes %s and %s", codes[0], code)
+				}
+			}
+			if n := len(s.List()); n != 1 {
+				t.Errorf("store holds %d entries, want 1", n)
+			}
+			checkFingers(t, s)
+		})
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clipboard.json")
+	now := time.Now().Round(0)
+
+	s, err := NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	entries := []Entry{
+		{Code: "text", Content: "hello", Finger: 1, CreatedAt: now},
+		{Code: "file", Attachment: &Attachment{Name: "a.png", Type: "image/png", Data: []byte{1, 2, 3}}, Finger: 2, CreatedAt: now.Add(time.Second)},
+		{Code: "limited", Content: "secret", Finger: 3, CreatedAt: now.Add(2 * time.Second), MaxReads: 2},
+		{Code: "expired", Content: "old", Finger: 4, CreatedAt: now, ExpiresAt: now.Add(time.Millisecond)},
+	}
+	for _, e := range entries {
+		if err := s.Put(e); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if _, _, err := s.Consume("limited"); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	reloaded, err := NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := reloaded.Get("expired"); ok {
+		t.Error("expired entry survived the reload")
+	}
+	for _, want := range entries[:3] {
+		got, ok := reloaded.Get(want.Code)
+		if !ok {
+			t.Errorf("entry %s lost on reload", want.Code)
+			continue
+		}
+		if !got.SameContent(want) || !got.CreatedAt.Equal(want.CreatedAt) || got.MaxReads != want.MaxReads {
+			t.Errorf("entry %s reloaded as %+v, want %+v", want.Code, got, want)
+		}
+	}
+	if got, _ := reloaded.Get("limited"); got.Reads != 1 {
+		t.Errorf("limited entry reloaded with %d reads, want 1", got.Reads)
+	}
+	checkFingers(t, reloaded)
+
+	// the last allowed read removes the entry from the file too
+	if _, _, err := reloaded.Consume("limited"); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	again, err := NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := again.Get("limited"); ok {
+		t.Error("used up entry survived the reload")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	now := time.Now()
+	for kind, s := range stores(t, 0) {
+		t.Run(kind, func(t *testing.T) {
+			if err := s.Put(Entry{Code: "a", Content: "hello", Finger: 1, CreatedAt: now, MaxReads: 1}); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			e, err := s.Update("a", func(e *Entry) error {
+				e.MaxReads = 3
+				e.Code = "b"
+				return nil
+			})
+			if err != nil || e.Code != "a" || e.MaxReads != 3 {
+				t.Fatalf("Update = %+v, %v", e, err)
+			}
+			if _, err := s.Update("a", func(e *Entry) error { return ErrCodeExists }); err != ErrCodeExists {
+				t.Errorf("Update passed on %v, want ErrCodeExists", err)
+			}
+
+			// an entry removed meanwhile is not brought back
+			if err := s.Delete("a"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := s.Update("a", func(e *Entry) error { return nil }); err != ErrNotFound {
+				t.Errorf("Update of a removed entry returned %v, want ErrNotFound", err)
+			}
+			if _, ok := s.Get("a"); ok {
+				t.Error("Update brought a removed entry back")
+			}
+			checkFingers(t, s)
+		})
+	}
+}
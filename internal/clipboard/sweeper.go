@@ -0,0 +1,32 @@
+package clipboard
+
+import (
+	"log"
+	"time"
+)
+
+// StartSweeper removes expired entries from s every interval until the
+// returned stop function is called
+func StartSweeper(s Store, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				n, err := s.Sweep(now)
+				if err != nil {
+					log.Printf("Clipboard sweep failed: %v", err)
+				} else if n > 0 {
+					log.Printf("Clipboard sweep removed %d expired entries", n)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+)
+
+// BanHandler shows and manages the ban list and the allowlist
+type BanHandler struct {
+	FS   fs.FS
+	Bans *auth.Banner
+}
+
+// NewBanHandler creates a new BanHandler
+func NewBanHandler(fs fs.FS, bans *auth.Banner) *BanHandler {
+	return &BanHandler{
+		FS:   fs,
+		Bans: bans,
+	}
+}
+
+// banView is a ban list entry as returned by the API
+type banView struct {
+	auth.BanInfo
+	Banned bool `json:"banned"`
+}
+
+// BansHandler serves the page listing banned and suspicious addresses
+func (h *BanHandler) BansHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFS(
+		h.FS,
+		"templates/base.html",
+		"templates/bans.html",
+	)
+	if err != nil {
+		http.Error(w, "Failed to parse template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	banMinutes := int(h.Bans.BanTime.Minutes())
+	if banMinutes < 1 {
+		banMinutes = 1
+	}
+
+	data := struct {
+		pageData
+		BanMinutes int
+	}{
+		pageData:   newPageData(r, "Ban List"),
+		BanMinutes: banMinutes,
+	}
+
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		http.Error(w, "Failed to execute template: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// BansAPIHandler manages the ban list as JSON:
+//
+//	GET    list the addresses with failures or bans, and the allowlist
+//	POST   ban addr for minutes
+//	DELETE unban addr
+func (h *BanHandler) BansAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		now := h.Bans.Now()
+		list := h.Bans.List()
+		views := make([]banView, 0, len(list))
+		for _, info := range list {
+			views = append(views, banView{BanInfo: info, Banned: now.Before(info.BannedUntil)})
+		}
+		writeJSON(w, struct {
+			Entries []banView `json:"entries"`
+			Allow   []string  `json:"allow"`
+		}{
+			Entries: views,
+			Allow:   h.Bans.Allowlist(),
+		})
+	case http.MethodPost:
+		minutes, err := strconv.Atoi(r.FormValue("minutes"))
+		if err != nil || minutes < 1 {
+			http.Error(w, "Invalid ban time", http.StatusBadRequest)
+			return
+		}
+		err = h.Bans.Ban(strings.TrimSpace(r.FormValue("addr")), time.Duration(minutes)*time.Minute)
+		if err == auth.ErrInvalidAddress {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save ban list: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		err := h.Bans.Unban(r.URL.Query().Get("addr"))
+		if err != nil {
+			http.Error(w, "Failed to save ban list: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AllowAPIHandler adds an IP or CIDR to the allowlist on POST and removes
+// it on DELETE, both named by addr
+func (h *BanHandler) AllowAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		err = h.Bans.Allow(strings.TrimSpace(r.FormValue("addr")))
+	case http.MethodDelete:
+		err = h.Bans.Disallow(r.URL.Query().Get("addr"))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err == auth.ErrInvalidAddress {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save ban list: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
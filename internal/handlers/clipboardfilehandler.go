@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ClipboardFileHandler converts between clipboard text and files on the PC
+type ClipboardFileHandler struct {
+	Directory string
+	UploadDir string
+	MaxSize   int64
+}
+
+// NewClipboardFileHandler creates a new ClipboardFileHandler, texts are saved
+// into uploadDir and loaded from directory, up to maxSize bytes
+func NewClipboardFileHandler(directory, uploadDir string, maxSize int64) *ClipboardFileHandler {
+	return &ClipboardFileHandler{
+		Directory: directory,
+		UploadDir: uploadDir,
+		MaxSize:   maxSize,
+	}
+}
+
+// SaveFileHandler writes the content form value into the upload directory
+// as name plus the optional ext, e.g. name=build&ext=log
+func (h *ClipboardFileHandler) SaveFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxSize+1<<10)
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "File name cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if ext := strings.TrimPrefix(strings.TrimSpace(r.FormValue("ext")), "."); ext != "" {
+		name += "." + ext
+	}
+
+	uploadDir := userUploadDir(r, h.UploadDir)
+	err = ensureDir(uploadDir)
+	if err != nil {
+		http.Error(w, "Failed to create upload directory: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fullPath, err := saveToDir(uploadDir, name, strings.NewReader(r.FormValue("content")))
+	if err != nil {
+		http.Error(w, "Failed to save file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Clipboard saved as %s", fullPath)
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = w.Write([]byte(filepath.Base(fullPath)))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// LoadFileHandler returns the text file named by the path query parameter,
+// relative to the shared directory
+func (h *ClipboardFileHandler) LoadFileHandler(w http.ResponseWriter, r *http.Request) {
+	relPath := strings.TrimPrefix(path.Clean("/"+r.URL.Query().Get("path")), "/")
+	if relPath == "" {
+		http.Error(w, "Path parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(userRoot(r, h.Directory), filepath.FromSlash(relPath)))
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if fi.Size() > h.MaxSize {
+		http.Error(w, "File too large for the clipboard", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	b, err := io.ReadAll(io.LimitReader(f, h.MaxSize))
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !utf8.Valid(b) {
+		http.Error(w, "Not a text file", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write(b)
+	if err != nil {
+		log.Println(err)
+	}
+}
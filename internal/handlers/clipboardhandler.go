@@ -1,38 +1,51 @@
 package handlers
 
 import (
+	"fmt"
 	"hash/fnv"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
-	mathRand "math/rand"
 	"net/http"
-	"sync"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/clipboard"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
 )
 
 // ClipboardHandler handles clipboard-related requests
 type ClipboardHandler struct {
-	FS            fs.FS
-	BaseURI       string
-	clipboardData map[string]string
-	fingerMap     map[uint64]string
-	mutex         sync.RWMutex
+	FS      fs.FS
+	Store   clipboard.Store
+	TTL     time.Duration
+	Codes   clipboard.CodeGenerator
+	Limiter *utils.RateLimiter
+	// MaxAttachmentSize is the largest file in bytes an entry may carry
+	MaxAttachmentSize int64
 }
 
-// NewClipboardHandler creates a new ClipboardHandler
-func NewClipboardHandler(fs fs.FS, baseURI string) *ClipboardHandler {
+// NewClipboardHandler creates a new ClipboardHandler, entries expire after
+// ttl unless it is zero. Failed retrievals are rate limited per client by
+// limiter when it is not nil.
+func NewClipboardHandler(fs fs.FS, store clipboard.Store, ttl time.Duration,
+	codes clipboard.CodeGenerator, limiter *utils.RateLimiter, maxAttachmentSize int64) *ClipboardHandler {
 	return &ClipboardHandler{
-		FS:            fs,
-		BaseURI:       baseURI,
-		clipboardData: make(map[string]string),
-		fingerMap:     make(map[uint64]string),
-		mutex:         sync.RWMutex{},
+		FS:                fs,
+		Store:             store,
+		TTL:               ttl,
+		Codes:             codes,
+		Limiter:           limiter,
+		MaxAttachmentSize: maxAttachmentSize,
 	}
 }
 
 // ClipboardIndexHandler serves the clipboard page
-func (h *ClipboardHandler) ClipboardIndexHandler(w http.ResponseWriter, _ *http.Request) {
+func (h *ClipboardHandler) ClipboardIndexHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl, err := template.ParseFS(
 		h.FS,
 		"templates/base.html",
@@ -44,17 +57,11 @@ func (h *ClipboardHandler) ClipboardIndexHandler(w http.ResponseWriter, _ *http.
 	}
 
 	data := struct {
-		Title       string
-		GetFiles    string
-		UploadFiles string
-		Clipboard   string
-		ToQrcode    string
+		pageData
+		MaxAttachmentSize int64
 	}{
-		Title:       "Online Clipboard",
-		GetFiles:    "/file/",
-		UploadFiles: "/upload",
-		Clipboard:   "/clipboard",
-		ToQrcode:    "/qrcode",
+		pageData:          newPageData(r, "Online Clipboard"),
+		MaxAttachmentSize: h.MaxAttachmentSize,
 	}
 
 	err = tmpl.Execute(w, data)
@@ -70,38 +77,61 @@ func (h *ClipboardHandler) GenerateClipboardCode(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err := r.ParseForm()
+	// leave room for the text and multipart overhead next to the attachment
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxAttachmentSize+8<<20)
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = r.ParseMultipartForm(32 << 20) // 32MB max memory
+	} else {
+		err = r.ParseForm()
+	}
 	if err != nil {
 		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	attachment, status, err := h.readAttachment(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
 	content := r.FormValue("content")
-	if content == "" {
+	if content == "" && attachment == nil {
 		http.Error(w, "Content cannot be empty", http.StatusBadRequest)
 		return
 	}
 
-	finger := fingerprint([]byte(content))
-	h.mutex.RLock()
-	code, ok := h.fingerMap[finger]
-	h.mutex.RUnlock()
-	if !ok {
-		for i := 0; i < 10; i++ {
-			code = generateUniqueCode()
-			h.mutex.RLock()
-			_, ok := h.clipboardData[code]
-			h.mutex.RUnlock()
-			if !ok {
-				break
-			}
-		}
+	maxReads, ttl, err := h.entryOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	encrypted := r.FormValue("encrypted") == "true"
+	if encrypted && attachment != nil {
+		http.Error(w, "Attachments cannot be encrypted", http.StatusBadRequest)
+		return
+	}
 
-		// Store content with code and finger
-		h.mutex.Lock()
-		h.clipboardData[code] = content
-		h.fingerMap[finger] = code
-		h.mutex.Unlock()
+	// Store content with a fresh code and finger, entries without options
+	// are shared between identical contents
+	now := time.Now()
+	entry := clipboard.Entry{
+		Owner:      userName(r),
+		Content:    content,
+		Attachment: attachment,
+		Encrypted:  encrypted,
+		Finger:     fingerprint(content, attachment),
+		CreatedAt:  now,
+		MaxReads:   maxReads,
+	}
+	if ttl > 0 {
+		entry.ExpiresAt = now.Add(ttl)
+	}
+	code, err := h.insertWithUniqueCode(entry, ttl == h.TTL)
+	if err != nil {
+		http.Error(w, "Failed to store content: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "text/plain")
@@ -111,43 +141,213 @@ func (h *ClipboardHandler) GenerateClipboardCode(w http.ResponseWriter, r *http.
 	}
 }
 
-func fingerprint(b []byte) uint64 {
+// entryOptions reads the optional "reads" limit and "expires" minutes of a
+// new entry, expiry is capped by the handler TTL
+func (h *ClipboardHandler) entryOptions(r *http.Request) (int, time.Duration, error) {
+	maxReads := 0
+	if v := r.FormValue("reads"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("Invalid reads: %q", v)
+		}
+		maxReads = n
+	}
+
+	ttl := h.TTL
+	if v := r.FormValue("expires"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 0 {
+			return 0, 0, fmt.Errorf("Invalid expires: %q", v)
+		}
+		custom := time.Duration(minutes) * time.Minute
+		if minutes > 0 && (h.TTL == 0 || custom < h.TTL) {
+			ttl = custom
+		}
+	}
+	return maxReads, ttl, nil
+}
+
+// readAttachment returns the file uploaded in the "file" field, nil when
+// there is none, together with the HTTP status to report on failure
+func (h *ClipboardHandler) readAttachment(r *http.Request) (*clipboard.Attachment, int, error) {
+	if r.MultipartForm == nil {
+		return nil, http.StatusOK, nil
+	}
+
+	file, header, err := r.FormFile("file")
+	if err == http.ErrMissingFile {
+		return nil, http.StatusOK, nil
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Failed to read attachment: %w", err)
+	}
+	defer file.Close()
+
+	if header.Size > h.MaxAttachmentSize {
+		return nil, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("Attachment larger than %d bytes", h.MaxAttachmentSize)
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Failed to read attachment: %w", err)
+	}
+
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+
+	name := filepath.Base(header.Filename)
+	if name == "." || name == string(filepath.Separator) {
+		name = "attachment"
+	}
+
+	return &clipboard.Attachment{
+		Name: name,
+		Type: contentType,
+		Data: data,
+	}, http.StatusOK, nil
+}
+
+func fingerprint(content string, attachment *clipboard.Attachment) uint64 {
 	hash := fnv.New64a()
-	hash.Write(b)
+	hash.Write([]byte(content))
+	if attachment != nil {
+		hash.Write([]byte{0})
+		hash.Write([]byte(attachment.Name))
+		hash.Write([]byte{0})
+		hash.Write(attachment.Data)
+	}
 	return hash.Sum64()
 }
 
-func generateUniqueCode() string {
-	const charset = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
-	codeLength := 3
-	b := make([]byte, codeLength)
-	r := mathRand.New(mathRand.NewSource(time.Now().UnixNano()))
-	for i := range b {
-		b[i] = charset[r.Intn(len(charset))]
+// insertWithUniqueCode stores entry under a code no live entry uses and
+// returns it, or the code of an identical entry when dedupe is set. The
+// codes get longer after repeated collisions so a free one is always found.
+func (h *ClipboardHandler) insertWithUniqueCode(entry clipboard.Entry, dedupe bool) (string, error) {
+	const collisionsPerLength = 8
+	for attempt := 0; ; attempt++ {
+		code, err := h.Codes.Generate(attempt / collisionsPerLength)
+		if err != nil {
+			return "", err
+		}
+
+		entry.Code = code
+		stored, err := h.Store.Insert(entry, dedupe)
+		if err == clipboard.ErrCodeExists {
+			continue
+		}
+		return stored.Code, err
 	}
-	return string(b)
 }
 
-// RetrieveClipboardContent retrieves content by code
+// RetrieveClipboardContent retrieves content by code, as plain text or,
+// with format=json, together with the attachment details
 func (h *ClipboardHandler) RetrieveClipboardContent(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		http.Error(w, "Code parameter is required", http.StatusBadRequest)
+	entry, ok := h.lookup(w, r)
+	if !ok {
 		return
 	}
 
-	h.mutex.RLock()
-	content, exists := h.clipboardData[code]
-	h.mutex.RUnlock()
+	if r.URL.Query().Get("format") != "json" {
+		w.Header().Set("Content-Type", "text/plain")
+		_, err := w.Write([]byte(entry.Content))
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
 
-	if !exists {
-		http.Error(w, "Invalid code or content not found", http.StatusNotFound)
+	type attachmentInfo struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Size int    `json:"size"`
+		URL  string `json:"url,omitempty"`
+		Data []byte `json:"data,omitempty"`
+	}
+	resp := struct {
+		Code       string          `json:"code"`
+		Content    string          `json:"content"`
+		Encrypted  bool            `json:"encrypted"`
+		Attachment *attachmentInfo `json:"attachment,omitempty"`
+	}{
+		Code:      entry.Code,
+		Content:   entry.Content,
+		Encrypted: entry.Encrypted,
+	}
+	if a := entry.Attachment; a != nil {
+		resp.Attachment = &attachmentInfo{
+			Name: a.Name,
+			Type: a.Type,
+			Size: len(a.Data),
+		}
+		// fetching the attachment would count as another read, so entries
+		// with a read limit carry the file inline
+		if entry.MaxReads > 0 {
+			resp.Attachment.Data = a.Data
+		} else {
+			resp.Attachment.URL = utils.RequestBaseURI(r) + "/clipboard/attachment?code=" + url.QueryEscape(entry.Code)
+		}
+	}
+
+	writeJSON(w, resp)
+}
+
+// AttachmentHandler serves the file attached to an entry, raster images are shown inline
+func (h *ClipboardHandler) AttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.lookup(w, r)
+	if !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	_, err := w.Write([]byte(content))
+	a := entry.Attachment
+	if a == nil {
+		http.Error(w, "No attachment for this code", http.StatusNotFound)
+		return
+	}
+
+	// svg is left out as it may carry scripts
+	disposition := "attachment"
+	if strings.HasPrefix(a.Type, "image/") && a.Type != "image/svg+xml" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", a.Type)
+	w.Header().Set("Content-Disposition", disposition+"; filename*=UTF-8''"+url.PathEscape(a.Name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err := w.Write(a.Data)
 	if err != nil {
 		log.Println(err)
 	}
 }
+
+// lookup consumes one retrieval of the entry named by the code query
+// parameter, rate limiting clients guessing codes. It writes the error
+// response when it fails.
+func (h *ClipboardHandler) lookup(w http.ResponseWriter, r *http.Request) (clipboard.Entry, bool) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Code parameter is required", http.StatusBadRequest)
+		return clipboard.Entry{}, false
+	}
+
+	ip := utils.RemoteIP(r)
+	if h.Limiter != nil && h.Limiter.Exhausted(ip) {
+		http.Error(w, "Too many invalid codes, try again later", http.StatusTooManyRequests)
+		return clipboard.Entry{}, false
+	}
+
+	entry, exists, err := h.Store.Consume(code)
+	if err != nil {
+		http.Error(w, "Failed to update clipboard store: "+err.Error(), http.StatusInternalServerError)
+		return clipboard.Entry{}, false
+	}
+	if !exists {
+		if h.Limiter != nil {
+			h.Limiter.Take(ip)
+		}
+		http.Error(w, "Invalid code or content not found", http.StatusNotFound)
+		return clipboard.Entry{}, false
+	}
+	return entry, true
+}
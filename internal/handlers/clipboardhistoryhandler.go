@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/clipboard"
+)
+
+const previewLength = 120
+
+// entryInfo describes a clipboard entry in the management API
+type entryInfo struct {
+	Code           string     `json:"code"`
+	Preview        string     `json:"preview"`
+	Content        string     `json:"content,omitempty"`
+	Size           int        `json:"size"`
+	AttachmentName string     `json:"attachment_name,omitempty"`
+	AttachmentType string     `json:"attachment_type,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	MaxReads       int        `json:"max_reads"`
+	Reads          int        `json:"reads"`
+	Burn           bool       `json:"burn"`
+	Encrypted      bool       `json:"encrypted"`
+}
+
+func newEntryInfo(e clipboard.Entry, withContent bool) entryInfo {
+	info := entryInfo{
+		Code:      e.Code,
+		Preview:   "(encrypted)",
+		Size:      len(e.Content),
+		CreatedAt: e.CreatedAt,
+		MaxReads:  e.MaxReads,
+		Reads:     e.Reads,
+		Burn:      e.MaxReads > 0 && e.MaxReads-e.Reads == 1,
+		Encrypted: e.Encrypted,
+	}
+	switch {
+	case e.MaxReads > 0:
+		// only retrievals count against the read limit, so nothing of the
+		// content is shown here
+		info.Preview = "(read limited)"
+		withContent = false
+	case !e.Encrypted:
+		info.Preview = preview(e.Content)
+	}
+	if withContent {
+		info.Content = e.Content
+	}
+	if !e.ExpiresAt.IsZero() {
+		expires := e.ExpiresAt
+		info.ExpiresAt = &expires
+	}
+	if a := e.Attachment; a != nil {
+		info.Size += len(a.Data)
+		if e.MaxReads == 0 {
+			info.AttachmentName = a.Name
+			info.AttachmentType = a.Type
+		}
+	}
+	return info
+}
+
+func preview(s string) string {
+	if utf8.RuneCountInString(s) <= previewLength {
+		return s
+	}
+	return string([]rune(s)[:previewLength]) + "…"
+}
+
+// HistoryHandler serves the page listing the clipboard entries
+func (h *ClipboardHandler) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFS(
+		h.FS,
+		"templates/base.html",
+		"templates/clipboardhistory.html",
+	)
+	if err != nil {
+		http.Error(w, "Failed to parse template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := newPageData(r, "Clipboard History")
+
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		http.Error(w, "Failed to execute template: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// EntriesAPIHandler manages the clipboard entries as JSON, without counting retrievals:
+//
+//	GET    list the entries, or return one with its content when code is given,
+//	       read-limited entries never show their content
+//	DELETE remove the entry named by code
+//	POST   set burn=true to remove the entry named by code after its next
+//	       retrieval, read limits are never lifted or raised
+//
+// Users only see and change their own entries, admins see all of them
+func (h *ClipboardHandler) EntriesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Code parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		entries := h.Store.List()
+		infos := make([]entryInfo, 0, len(entries))
+		for _, e := range entries {
+			if ownsEntry(r, e) {
+				infos = append(infos, newEntryInfo(e, false))
+			}
+		}
+		writeJSON(w, infos)
+		return
+	}
+
+	entry, ok := h.Store.Get(code)
+	if !ok || !ownsEntry(r, entry) {
+		http.Error(w, "Invalid code or content not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if entry.MaxReads > 0 {
+			http.Error(w, "Read-limited content is only available by retrieving its code", http.StatusForbidden)
+			return
+		}
+		writeJSON(w, newEntryInfo(entry, true))
+	case http.MethodDelete:
+		err := h.Store.Delete(code)
+		if err != nil {
+			http.Error(w, "Failed to delete entry: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		if r.FormValue("burn") != "true" {
+			http.Error(w, "Read limits can only be tightened, send burn=true", http.StatusBadRequest)
+			return
+		}
+		entry, err := h.Store.Update(code, func(e *clipboard.Entry) error {
+			if e.MaxReads == 0 || e.MaxReads > e.Reads+1 {
+				e.MaxReads = e.Reads + 1
+			}
+			return nil
+		})
+		if err == clipboard.ErrNotFound {
+			http.Error(w, "Invalid code or content not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to update entry: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, newEntryInfo(entry, false))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ownsEntry reports whether the user of r may manage e
+func ownsEntry(r *http.Request, e clipboard.Entry) bool {
+	u := auth.UserFromRequest(r)
+	return u == nil || u.Can(auth.PermAdmin) || u.User == e.Owner
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println(err)
+	}
+}
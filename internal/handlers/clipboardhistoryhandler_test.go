@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/clipboard"
+)
+
+func TestEntriesAPIBurn(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxReads     int
+		reads        int
+		body         string
+		wantStatus   int
+		wantMaxReads int
+	}{
+		{name: "burn unlimited", body: "burn=true", wantStatus: http.StatusOK, wantMaxReads: 1},
+		{name: "burn after reads", reads: 2, body: "burn=true", wantStatus: http.StatusOK, wantMaxReads: 3},
+		{name: "burn n reads", maxReads: 5, reads: 1, body: "burn=true", wantStatus: http.StatusOK, wantMaxReads: 2},
+		{name: "burn burnt", maxReads: 2, reads: 1, body: "burn=true", wantStatus: http.StatusOK, wantMaxReads: 2},
+		{name: "lift limit", maxReads: 1, body: "burn=false", wantStatus: http.StatusBadRequest, wantMaxReads: 1},
+		{name: "lift n reads", maxReads: 5, body: "", wantStatus: http.StatusBadRequest, wantMaxReads: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := clipboard.NewMemoryStore(0)
+			err := store.Put(clipboard.Entry{
+				Code:      "abcd",
+				Content:   "secret",
+				CreatedAt: time.Now(),
+				MaxReads:  tt.maxReads,
+				Reads:     tt.reads,
+			})
+			if err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			h := NewClipboardHandler(nil, store, time.Hour, clipboard.CodeGenerator{}, nil, 1<<20)
+
+			r := httptest.NewRequest(http.MethodPost, "/clipboard/api/entries?code=abcd", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			h.EntriesAPIHandler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			entry, ok := store.Get("abcd")
+			if !ok {
+				t.Fatal("entry is gone")
+			}
+			if entry.MaxReads != tt.wantMaxReads {
+				t.Errorf("MaxReads = %d, want %d", entry.MaxReads, tt.wantMaxReads)
+			}
+
+			// a read-limited entry never hands out its content here
+			r = httptest.NewRequest(http.MethodGet, "/clipboard/api/entries?code=abcd", nil)
+			w = httptest.NewRecorder()
+			h.EntriesAPIHandler(w, r)
+			if entry.MaxReads > 0 && (w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "secret")) {
+				t.Errorf("GET of a read-limited entry = %d %q", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestEntriesAPIBurnRemoved(t *testing.T) {
+	store := clipboard.NewMemoryStore(0)
+	h := NewClipboardHandler(nil, store, time.Hour, clipboard.CodeGenerator{}, nil, 1<<20)
+
+	r := httptest.NewRequest(http.MethodPost, "/clipboard/api/entries?code=gone", strings.NewReader("burn=true"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.EntriesAPIHandler(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if len(store.List()) != 0 {
+		t.Error("burning a missing entry created it")
+	}
+}
+
+func TestEntriesAPIOwner(t *testing.T) {
+	store := clipboard.NewMemoryStore(0)
+	for _, e := range []clipboard.Entry{
+		{Code: "mom1", Owner: "mom", Content: "mom's", CreatedAt: time.Now()},
+		{Code: "kid1", Owner: "kid", Content: "kid's", CreatedAt: time.Now()},
+	} {
+		if err := store.Put(e); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	h := NewClipboardHandler(nil, store, time.Hour, clipboard.CodeGenerator{}, nil, 1<<20)
+
+	mom := &auth.User{Credentials: auth.Credentials{User: "mom"}, Permissions: []auth.Permission{auth.PermClipboard}}
+	admin := &auth.User{Credentials: auth.Credentials{User: "admin"}, Permissions: []auth.Permission{auth.PermAdmin}}
+
+	serve := func(u *auth.User, method, query, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/clipboard/api/entries"+query, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.EntriesAPIHandler(w, auth.WithUser(r, u))
+		return w
+	}
+
+	w := serve(mom, http.MethodGet, "", "")
+	if !strings.Contains(w.Body.String(), "mom1") || strings.Contains(w.Body.String(), "kid1") {
+		t.Errorf("list of mom = %s", w.Body.String())
+	}
+	w = serve(admin, http.MethodGet, "", "")
+	if !strings.Contains(w.Body.String(), "mom1") || !strings.Contains(w.Body.String(), "kid1") {
+		t.Errorf("list of admin = %s", w.Body.String())
+	}
+
+	tests := []struct {
+		method string
+		body   string
+	}{
+		{method: http.MethodGet},
+		{method: http.MethodPost, body: "burn=true"},
+		{method: http.MethodDelete},
+	}
+	for _, tt := range tests {
+		if w := serve(mom, tt.method, "?code=kid1", tt.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s of another user's entry = %d, want %d", tt.method, w.Code, http.StatusNotFound)
+		}
+	}
+	if e, ok := store.Get("kid1"); !ok || e.MaxReads != 0 {
+		t.Error("mom changed the entry of kid")
+	}
+
+	if w := serve(admin, http.MethodDelete, "?code=kid1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE by admin = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(mom, http.MethodDelete, "?code=mom1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE by owner = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/clipboard"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+// ClipboardSyncHandler handles the live clipboard shared by all connected devices
+type ClipboardSyncHandler struct {
+	Hub     *clipboard.Hub
+	MaxSize int64
+}
+
+// NewClipboardSyncHandler creates a new ClipboardSyncHandler accepting texts up to maxSize bytes
+func NewClipboardSyncHandler(hub *clipboard.Hub, maxSize int64) *ClipboardSyncHandler {
+	return &ClipboardSyncHandler{
+		Hub:     hub,
+		MaxSize: maxSize,
+	}
+}
+
+// PublishHandler posts the content form value to every connected device
+func (h *ClipboardSyncHandler) PublishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxSize)
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	content := r.FormValue("content")
+	if content == "" {
+		http.Error(w, "Content cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	h.Hub.Publish(content, utils.RemoteIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EventsHandler streams the history and every new message as Server-Sent Events
+func (h *ClipboardSyncHandler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	messages, history, cancel := h.Hub.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	for _, msg := range history {
+		if err := writeEvent(w, msg); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case msg := <-messages:
+			if err := writeEvent(w, msg); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, msg clipboard.Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", msg.ID, b)
+	return err
+}
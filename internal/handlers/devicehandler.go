@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+// DeviceHandler lets new devices ask for access and the owner approve them
+type DeviceHandler struct {
+	FS       fs.FS
+	Users    *auth.Users
+	Devices  *auth.Devices
+	Sessions *auth.SessionStore
+}
+
+// NewDeviceHandler creates a new DeviceHandler, revoking a device ends its
+// sessions in sessions
+func NewDeviceHandler(fs fs.FS, users *auth.Users, devices *auth.Devices, sessions *auth.SessionStore) *DeviceHandler {
+	return &DeviceHandler{
+		FS:       fs,
+		Users:    users,
+		Devices:  devices,
+		Sessions: sessions,
+	}
+}
+
+// deviceView is a device as returned by the API, without its token hash
+type deviceView struct {
+	ID          string            `json:"id"`
+	Code        string            `json:"code"`
+	Addr        string            `json:"addr"`
+	UserAgent   string            `json:"user_agent"`
+	User        string            `json:"user,omitempty"`
+	Status      auth.DeviceStatus `json:"status"`
+	RequestedAt time.Time         `json:"requested_at"`
+	ApprovedAt  time.Time         `json:"approved_at"`
+	LastSeen    time.Time         `json:"last_seen"`
+}
+
+// RequestHandler serves the page a new device asks for access on. POST
+// records a request, GET shows its state, or returns it as JSON with
+// format=json and hands out the device token once the owner approved it
+func (h *DeviceHandler) RequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		dev, err := h.Devices.Request(utils.RemoteIP(r), r.UserAgent())
+		if err == auth.ErrTooManyRequests {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to create device request: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Device %s (%s) requests access with code %s, approve or deny at %s/devices",
+			dev.Addr, dev.UserAgent, dev.Code, utils.RequestBaseURI(r))
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.DeviceRequestCookieName,
+			Value:    dev.ID,
+			Path:     "/",
+			MaxAge:   int(auth.DeviceRequestLifetime.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, utils.RequestBaseURI(r)+"/device", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var dev auth.Device
+	var token string
+	if c, err := r.Cookie(auth.DeviceRequestCookieName); err == nil {
+		dev, token, _ = h.Devices.Claim(c.Value)
+	}
+	if token != "" {
+		auth.SetTokenCookie(w, r, token)
+		http.SetCookie(w, &http.Cookie{Name: auth.DeviceRequestCookieName, Path: "/", MaxAge: -1})
+		log.Printf("Device %s approved as %s", dev.Addr, dev.User)
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, struct {
+			Status auth.DeviceStatus `json:"status"`
+			Code   string            `json:"code"`
+		}{
+			Status: dev.Status,
+			Code:   dev.Code,
+		})
+		return
+	}
+
+	baseURI := utils.RequestBaseURI(r)
+	tmpl, err := template.ParseFS(h.FS, "templates/device.html")
+	if err != nil {
+		http.Error(w, "Failed to parse template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title  string
+		Base   string
+		Device string
+		Login  string
+		Status auth.DeviceStatus
+		Code   string
+	}{
+		Title:  "Device Access",
+		Base:   baseURI,
+		Device: baseURI + "/device",
+		Login:  baseURI + "/login",
+		Status: dev.Status,
+		Code:   dev.Code,
+	}
+
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("Failed to execute template: %v", err)
+	}
+}
+
+// DevicesHandler serves the page listing pending requests and approved devices
+func (h *DeviceHandler) DevicesHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFS(
+		h.FS,
+		"templates/base.html",
+		"templates/devices.html",
+	)
+	if err != nil {
+		http.Error(w, "Failed to parse template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		pageData
+		Request string
+		Owner   string
+	}{
+		pageData: newPageData(r, "Devices"),
+		Request:  utils.RequestBaseURI(r) + "/device",
+		Owner:    h.Users.Owner().User,
+	}
+
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		http.Error(w, "Failed to execute template: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DevicesAPIHandler manages devices as JSON:
+//
+//	GET    list the requests, approved devices and user names
+//	POST   approve the request id as user, or deny it with action=deny
+//	DELETE revoke the device id and end its sessions
+func (h *DeviceHandler) DevicesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := h.Devices.List()
+		views := make([]deviceView, 0, len(list))
+		for _, dev := range list {
+			views = append(views, newDeviceView(dev))
+		}
+		writeJSON(w, struct {
+			Devices []deviceView `json:"devices"`
+			Users   []string     `json:"users"`
+		}{
+			Devices: views,
+			Users:   h.Users.Names(),
+		})
+	case http.MethodPost:
+		id := r.FormValue("id")
+		var err error
+		switch r.FormValue("action") {
+		case "approve":
+			user := r.FormValue("user")
+			if _, ok := h.Users.Get(user); !ok {
+				http.Error(w, "Unknown user", http.StatusBadRequest)
+				return
+			}
+			err = h.Devices.Approve(id, user)
+		case "deny":
+			err = h.Devices.Deny(id)
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+		if err == auth.ErrDeviceNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save devices: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		err := h.Devices.Revoke(id)
+		if err == auth.ErrDeviceNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		h.Sessions.DestroyDevice(id)
+		if err != nil {
+			http.Error(w, "Failed to save devices: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func newDeviceView(dev auth.Device) deviceView {
+	return deviceView{
+		ID:          dev.ID,
+		Code:        dev.Code,
+		Addr:        dev.Addr,
+		UserAgent:   dev.UserAgent,
+		User:        dev.User,
+		Status:      dev.Status,
+		RequestedAt: dev.RequestedAt,
+		ApprovedAt:  dev.ApprovedAt,
+		LastSeen:    dev.LastSeen,
+	}
+}
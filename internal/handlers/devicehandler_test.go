@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+)
+
+func TestRevokeDeviceEndsSessions(t *testing.T) {
+	users, err := auth.NewUsers([]auth.User{
+		{Credentials: auth.Credentials{User: "admin", Password: "secret"}, Permissions: []auth.Permission{auth.PermAdmin}},
+		{Credentials: auth.Credentials{User: "mom", Password: "mompw"}, Permissions: []auth.Permission{auth.PermDownload}},
+	})
+	if err != nil {
+		t.Fatalf("NewUsers: %v", err)
+	}
+	sessions, err := auth.NewSessionStore(time.Hour, "/login")
+	if err != nil {
+		t.Fatalf("NewSessionStore: %v", err)
+	}
+	devices := auth.NewDevices()
+	h := NewDeviceHandler(nil, users, devices, sessions)
+
+	// newSession returns a request carrying a new session of mom, created for device
+	newSession := func(device string) *http.Request {
+		w := httptest.NewRecorder()
+		if err := sessions.CreateForDevice(w, httptest.NewRequest(http.MethodGet, "/", nil), "mom", device); err != nil {
+			t.Fatalf("CreateForDevice: %v", err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		return r
+	}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		dev, err := devices.Request("198.51.100.20", "phone")
+		if err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		if err := devices.Approve(dev.ID, "mom"); err != nil {
+			t.Fatalf("Approve: %v", err)
+		}
+		ids = append(ids, dev.ID)
+	}
+	revoked := newSession(ids[0])
+	other := newSession(ids[1])
+	password := newSession("")
+
+	w := httptest.NewRecorder()
+	h.DevicesAPIHandler(w, httptest.NewRequest(http.MethodDelete, "/devices/api?id="+ids[0], nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	if _, ok := sessions.User(revoked); ok {
+		t.Error("the session of the revoked device still works")
+	}
+	if _, ok := sessions.User(other); !ok {
+		t.Error("the session of another device ended")
+	}
+	if _, ok := sessions.User(password); !ok {
+		t.Error("a password session ended")
+	}
+}
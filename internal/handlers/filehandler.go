@@ -8,35 +8,41 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+
+	fsInternal "github.com/kumakichi/pc-mobile-file-exchanger/internal/fs"
 )
 
 // FileHandler handles file-related requests
 type FileHandler struct {
 	FS            fs.FS
-	BaseURI       string
 	Directory     string
 	FilterSuffix  string
 	PatchHTMLFile bool
 }
 
 // NewFileHandler creates a new FileHandler
-func NewFileHandler(fs fs.FS, baseURI, directory, filterSuffix string, patchHTMLFile bool) *FileHandler {
+func NewFileHandler(fs fs.FS, directory, filterSuffix string, patchHTMLFile bool) *FileHandler {
 	return &FileHandler{
 		FS:            fs,
-		BaseURI:       baseURI,
 		Directory:     directory,
 		FilterSuffix:  filterSuffix,
 		PatchHTMLFile: patchHTMLFile,
 	}
 }
 
-// WrapFSHandler wraps a filesystem handler with custom behavior
-func (h *FileHandler) WrapFSHandler(fileHandler http.Handler) http.HandlerFunc {
+// ServeFiles serves the shared directory of the requesting user
+func (h *FileHandler) ServeFiles(w http.ResponseWriter, r *http.Request) {
+	root := userRoot(r, h.Directory)
+	h.WrapFSHandler(root, http.FileServer(http.FS(fsInternal.SuffixDirFS(root))))(w, r)
+}
+
+// WrapFSHandler wraps a filesystem handler serving root with custom behavior
+func (h *FileHandler) WrapFSHandler(root string, fileHandler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		urlPath := r.URL.Path
 		log.Printf("WrapFSHandler called with URL path: %s", urlPath)
 
-		fullPath := filepath.Join(h.Directory, urlPath)
+		fullPath := filepath.Join(root, urlPath)
 		fi, err := os.Stat(fullPath)
 		if err != nil {
 			log.Printf("Error getting file info: %v", err)
@@ -68,27 +74,11 @@ func (h *FileHandler) WrapFSHandler(fileHandler http.Handler) http.HandlerFunc {
 			}
 
 			data := struct {
-				Title       string
+				pageData
 				FileContent template.HTML
-				GetFiles    string
-				UploadFiles string
-				Clipboard   string
-				ToQrcode    string
 			}{
-				Title:       "File Browser",
+				pageData:    newPageData(r, "File Browser"),
 				FileContent: template.HTML(rec.Body.String()),
-				GetFiles:    "/file/",
-				UploadFiles: "/upload",
-				Clipboard:   "/clipboard",
-				ToQrcode:    "/qrcode",
-			}
-
-			if h.BaseURI != "" {
-				log.Printf("Using full URLs with baseURI: %s", h.BaseURI)
-				data.GetFiles = h.BaseURI + "/file/"
-				data.UploadFiles = h.BaseURI + "/upload"
-				data.Clipboard = h.BaseURI + "/clipboard"
-				data.ToQrcode = h.BaseURI + "/qrcode"
 			}
 
 			err = tmpl.Execute(w, data)
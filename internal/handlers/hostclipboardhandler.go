@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/clipboard"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+// HostClipboardHandler reads and writes the clipboard of the PC running the server
+type HostClipboardHandler struct {
+	Bridge  clipboard.Bridge
+	MaxSize int64
+}
+
+// NewHostClipboardHandler creates a new HostClipboardHandler accepting texts up to maxSize bytes
+func NewHostClipboardHandler(bridge clipboard.Bridge, maxSize int64) *HostClipboardHandler {
+	return &HostClipboardHandler{
+		Bridge:  bridge,
+		MaxSize: maxSize,
+	}
+}
+
+// HostClipboard returns the PC clipboard on GET and replaces it with the
+// content form value on POST. HEAD only tells the page the bridge is enabled.
+func (h *HostClipboardHandler) HostClipboard(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		text, err := h.Bridge.Read()
+		if err != nil {
+			http.Error(w, "Failed to read PC clipboard: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, err = w.Write([]byte(text))
+		if err != nil {
+			log.Println(err)
+		}
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxSize)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = h.Bridge.Write(r.FormValue("content"))
+		if err != nil {
+			http.Error(w, "Failed to write PC clipboard: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("PC clipboard set by %s", utils.RemoteIP(r))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeBridge is an in-memory clipboard.Bridge
+type fakeBridge struct {
+	text string
+	err  error
+}
+
+func (b *fakeBridge) Read() (string, error) {
+	return b.text, b.err
+}
+
+func (b *fakeBridge) Write(text string) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.text = text
+	return nil
+}
+
+func TestHostClipboard(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		content    string
+		bridgeText string
+		bridgeErr  error
+		wantStatus int
+		wantBody   string
+		wantText   string
+	}{
+		{name: "head", method: http.MethodHead, wantStatus: http.StatusNoContent},
+		{name: "read", method: http.MethodGet, bridgeText: "from pc", wantStatus: http.StatusOK, wantBody: "from pc", wantText: "from pc"},
+		{name: "read error", method: http.MethodGet, bridgeErr: errors.New("no display"), wantStatus: http.StatusInternalServerError},
+		{name: "write", method: http.MethodPost, content: "from phone", bridgeText: "old", wantStatus: http.StatusNoContent, wantText: "from phone"},
+		{name: "write error", method: http.MethodPost, content: "x", bridgeErr: errors.New("no display"), wantStatus: http.StatusInternalServerError},
+		{name: "too large", method: http.MethodPost, content: strings.Repeat("x", 64), bridgeText: "old", wantStatus: http.StatusBadRequest, wantText: "old"},
+		{name: "method", method: http.MethodDelete, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bridge := &fakeBridge{text: tt.bridgeText, err: tt.bridgeErr}
+			h := NewHostClipboardHandler(bridge, 32)
+
+			var body *strings.Reader
+			if tt.method == http.MethodPost {
+				body = strings.NewReader(url.Values{"content": {tt.content}}.Encode())
+			} else {
+				body = strings.NewReader("")
+			}
+			r := httptest.NewRequest(tt.method, "/clipboard/host", body)
+			if tt.method == http.MethodPost {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			w := httptest.NewRecorder()
+			h.HostClipboard(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.bridgeErr == nil && bridge.text != tt.wantText {
+				t.Errorf("clipboard = %q, want %q", bridge.text, tt.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+// LoginHandler handles the login form and logging out of browser sessions
+type LoginHandler struct {
+	FS       fs.FS
+	Users    *auth.Users
+	Sessions *auth.SessionStore
+	Bans     *auth.Banner
+	Devices  *auth.Devices
+}
+
+// NewLoginHandler creates a new LoginHandler checking logins against users,
+// devices may be nil when device approval is disabled
+func NewLoginHandler(fs fs.FS, users *auth.Users, sessions *auth.SessionStore, bans *auth.Banner, devices *auth.Devices) *LoginHandler {
+	return &LoginHandler{
+		FS:       fs,
+		Users:    users,
+		Sessions: sessions,
+		Bans:     bans,
+		Devices:  devices,
+	}
+}
+
+// Login shows the login form and starts a session on valid credentials,
+// browsers already logged in or on an approved device are sent to their
+// start page
+func (h *LoginHandler) Login(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = ""
+	}
+
+	if name, ok := h.Sessions.User(r); ok && r.Method == http.MethodGet {
+		if user, ok := h.Users.Get(name); ok {
+			http.Redirect(w, r, startPage(r, user, next), http.StatusSeeOther)
+			return
+		}
+	}
+
+	if user, device, ok := h.deviceUser(r); ok && r.Method == http.MethodGet {
+		if err := h.Sessions.CreateForDevice(w, r, user.User, device); err != nil {
+			http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, startPage(r, user, next), http.StatusSeeOther)
+		return
+	}
+
+	wrongPassword := false
+	if r.Method == http.MethodPost {
+		ip := utils.RemoteIP(r)
+		if left := h.Bans.Banned(ip); left > 0 {
+			auth.TooManyAttempts(w, left)
+			return
+		}
+
+		if user, ok := h.Users.Authenticate(r.FormValue("username"), r.FormValue("password")); ok {
+			h.Bans.Succeed(ip)
+			if err := h.Sessions.Create(w, r, user.User); err != nil {
+				http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Login as %s from %s %s", user.User, utils.RemoteIP(r), r.UserAgent())
+			http.Redirect(w, r, startPage(r, user, next), http.StatusSeeOther)
+			return
+		}
+		log.Printf("Failed login from %s", utils.RemoteIP(r))
+		if h.Bans.Fail(ip) {
+			auth.TooManyAttempts(w, h.Bans.Banned(ip))
+			return
+		}
+		wrongPassword = true
+	}
+
+	tmpl, err := template.ParseFS(h.FS, "templates/login.html")
+	if err != nil {
+		http.Error(w, "Failed to parse template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title         string
+		Base          string
+		Next          string
+		Username      string
+		WrongPassword bool
+		Device        string
+	}{
+		Title:         "Login",
+		Base:          utils.RequestBaseURI(r),
+		Next:          next,
+		Username:      r.FormValue("username"),
+		WrongPassword: wrongPassword,
+	}
+	if h.Devices != nil {
+		data.Device = utils.RequestBaseURI(r) + "/device"
+	}
+
+	if wrongPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("Failed to execute template: %v", err)
+	}
+}
+
+// deviceUser returns the user the device token cookie of r logs in as and
+// the id of the device
+func (h *LoginHandler) deviceUser(r *http.Request) (*auth.User, string, bool) {
+	if h.Devices == nil {
+		return nil, "", false
+	}
+	c, err := r.Cookie(auth.DeviceCookieName)
+	if err != nil {
+		return nil, "", false
+	}
+	dev, ok := h.Devices.Authenticate(c.Value)
+	if !ok {
+		return nil, "", false
+	}
+	user, ok := h.Users.Get(dev.User)
+	return user, dev.ID, ok
+}
+
+// startPage returns next, or the first page user is allowed to see when next
+// is empty, under the base URI of r
+func startPage(r *http.Request, user *auth.User, next string) string {
+	baseURI := utils.RequestBaseURI(r)
+	switch {
+	case next != "":
+		return baseURI + next
+	case user.Can(auth.PermDownload):
+		return baseURI + "/file/"
+	case user.Can(auth.PermUpload):
+		return baseURI + "/upload"
+	case user.Can(auth.PermClipboard):
+		return baseURI + "/clipboard"
+	}
+	return baseURI + "/qrcode"
+}
+
+// Logout ends the browser session and returns to the login form
+func (h *LoginHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.Sessions.Destroy(w, r)
+	http.Redirect(w, r, utils.RequestBaseURI(r)+h.Sessions.LoginURL, http.StatusSeeOther)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+// pageData holds the fields used by the navigation bar in base.html
+type pageData struct {
+	Title       string
+	Base        string
+	GetFiles    string
+	UploadFiles string
+	Clipboard   string
+	ToQrcode    string
+	Shares      string
+	Bans        string
+	Devices     string
+	Logout      string
+	CSRFToken   string
+	CanDownload bool
+	CanUpload   bool
+	CanClip     bool
+	IsAdmin     bool
+}
+
+// newPageData builds the navigation links from the base URI of r, the CSRF
+// token is empty unless r is authenticated by a browser session
+func newPageData(r *http.Request, title string) pageData {
+	user := auth.UserFromRequest(r)
+	baseURI := utils.RequestBaseURI(r)
+	return pageData{
+		Title:       title,
+		Base:        baseURI,
+		GetFiles:    baseURI + "/file/",
+		UploadFiles: baseURI + "/upload",
+		Clipboard:   baseURI + "/clipboard",
+		ToQrcode:    baseURI + "/qrcode",
+		Shares:      baseURI + "/share",
+		Bans:        baseURI + "/bans",
+		Devices:     baseURI + "/devices",
+		Logout:      baseURI + "/logout",
+		CSRFToken:   auth.CSRFToken(r),
+		CanDownload: auth.Allowed(r, auth.PermDownload),
+		CanUpload:   auth.Allowed(r, auth.PermUpload),
+		CanClip:     auth.Allowed(r, auth.PermClipboard),
+		IsAdmin:     user != nil && user.Can(auth.PermAdmin),
+	}
+}
@@ -4,50 +4,194 @@ import (
 	"encoding/base64"
 	"html/template"
 	"io/fs"
+	"log"
 	"net/http"
 	"net/url"
+	"path"
+	"strconv"
+	"strings"
 
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
 	"github.com/skip2/go-qrcode"
 )
 
 // QRCodeHandler handles QR code generation requests
 type QRCodeHandler struct {
-	FS      fs.FS
-	BaseURI string
-	pattern string
+	FS          fs.FS
+	BaseURI     string
+	BaseURIs    []string
+	Pairing     *auth.Pairing
+	Fingerprint string
+	pattern     string
+	pairPattern string
 }
 
-// NewQRCodeHandler creates a new QRCodeHandler
-func NewQRCodeHandler(fs fs.FS, baseURI, pattern string) *QRCodeHandler {
+// NewQRCodeHandler creates a new QRCodeHandler. The main QR code page shows
+// one code per entry of baseURIs, the first one is used for links. When
+// pairing is not nil the codes log the scanning device in through pairPattern.
+// A non-empty fingerprint of the TLS certificate is shown below the codes.
+func NewQRCodeHandler(fs fs.FS, baseURIs []string, pattern string, pairing *auth.Pairing, pairPattern string, fingerprint string) *QRCodeHandler {
 	return &QRCodeHandler{
-		FS:      fs,
-		BaseURI: baseURI,
-		pattern: pattern,
+		FS:          fs,
+		BaseURI:     baseURIs[0],
+		BaseURIs:    baseURIs,
+		Pairing:     pairing,
+		Fingerprint: fingerprint,
+		pattern:     pattern,
+		pairPattern: pairPattern,
 	}
 }
 
-// QRCodeHandler generates and serves a QR code
-func (h *QRCodeHandler) QRCodeHandler(w http.ResponseWriter, _ *http.Request) {
-	u, err := url.JoinPath(h.BaseURI, h.pattern)
+// qrView is a QR code prepared for the qrcode template
+type qrView struct {
+	Base64  string
+	Caption string
+}
+
+// QRCodeHandler generates and serves a QR code per listening address
+func (h *QRCodeHandler) QRCodeHandler(w http.ResponseWriter, r *http.Request) {
+	refresh := 0
+	if h.Pairing != nil {
+		refresh = int(h.Pairing.TTL().Seconds() / 2)
+	}
+
+	codes := make([]qrView, 0, len(h.BaseURIs))
+	for _, baseURI := range h.BaseURIs {
+		u, err := h.mainContent(r, baseURI)
+		if err != nil {
+			http.Error(w, "Failed to build QR code content: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		base64Str, err := qrPNGBase64(u, 256)
+		if err != nil {
+			http.Error(w, "Failed to generate QR code: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		caption := ""
+		if len(h.BaseURIs) > 1 {
+			caption = baseURI
+		}
+		codes = append(codes, qrView{Base64: base64Str, Caption: caption})
+	}
+
+	h.render(w, r, "QR Code", codes, refresh)
+}
+
+// mainContent returns what the main QR code for baseURI encodes, pairing
+// tokens log in as the user viewing the code
+func (h *QRCodeHandler) mainContent(r *http.Request, baseURI string) (string, error) {
+	if user := auth.UserFromRequest(r); h.Pairing != nil && user != nil {
+		return h.PairingURL(baseURI, user.User)
+	}
+	return url.JoinPath(baseURI, h.pattern)
+}
+
+// PairingURL returns a URL below baseURI carrying the current one-time
+// pairing token of user
+func (h *QRCodeHandler) PairingURL(baseURI, user string) (string, error) {
+	token, err := h.Pairing.Current(user)
 	if err != nil {
-		http.Error(w, "Failed to join URL: "+err.Error(), http.StatusInternalServerError)
+		return "", err
+	}
+	return baseURI + h.pairPattern + "?token=" + url.QueryEscape(token), nil
+}
+
+// ImageHandler serves a bare QR code image, the format is taken from the last
+// path element: png, svg or txt. Query parameters:
+//
+//	content  text to encode, defaults to the main QR code of the first address
+//	size     image width in pixels for png and svg, 256 by default
+//	level    recovery level L, M, Q or H, M by default
+func (h *QRCodeHandler) ImageHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	content := q.Get("content")
+	if content == "" {
+		var err error
+		content, err = h.mainContent(r, h.BaseURI)
+		if err != nil {
+			http.Error(w, "Failed to build QR code content: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	size := 256
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 32 || n > 4096 {
+			http.Error(w, "Invalid size, expecting 32-4096", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	level, ok := recoveryLevels[strings.ToUpper(q.Get("level"))]
+	if !ok {
+		http.Error(w, "Invalid level, expecting L, M, Q or H", http.StatusBadRequest)
 		return
 	}
 
-	qr, err := qrcode.New(u, qrcode.Medium)
+	qr, err := qrcode.New(content, level)
 	if err != nil {
 		http.Error(w, "Failed to generate QR code: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	png, err := qr.PNG(256)
-	if err != nil {
-		http.Error(w, "Failed to encode QR code as PNG: "+err.Error(), http.StatusInternalServerError)
+	switch path.Base(r.URL.Path) {
+	case "png":
+		w.Header().Set("Content-Type", "image/png")
+		err = qr.Write(size, w)
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = utils.WriteQRCodeSVG(w, qr, size)
+	case "txt":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = w.Write([]byte(qr.ToSmallString(false)))
+	default:
+		http.Error(w, "Unknown format, expecting png, svg or txt", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+var recoveryLevels = map[string]qrcode.RecoveryLevel{
+	"":  qrcode.Medium,
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// FileQRCodeHandler serves a QR code for the download or folder URL of the
+// path following the pattern, e.g. /qrcode/file/docs/a.pdf
+func (h *QRCodeHandler) FileQRCodeHandler(w http.ResponseWriter, r *http.Request) {
+	relPath := strings.TrimPrefix(r.URL.Path, h.pattern+"/file/")
+	isDir := relPath == "" || strings.HasSuffix(relPath, "/")
+	relPath = strings.TrimPrefix(path.Clean("/"+relPath), "/")
+	if isDir && relPath != "" {
+		relPath += "/"
+	}
 
-	base64Str := base64.StdEncoding.EncodeToString(png)
+	u := h.BaseURI + (&url.URL{Path: "/file/" + relPath}).EscapedPath()
+	name := path.Base("/" + relPath)
+	if relPath == "" {
+		name = "/"
+	}
+	base64Str, err := qrPNGBase64(u, 256)
+	if err != nil {
+		http.Error(w, "Failed to generate QR code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, name, []qrView{{Base64: base64Str, Caption: u}}, 0)
+}
 
+// render shows the QR codes, the page reloads itself every refresh seconds when positive
+func (h *QRCodeHandler) render(w http.ResponseWriter, r *http.Request, title string, codes []qrView, refresh int) {
 	tmpl, err := template.ParseFS(
 		h.FS,
 		"templates/base.html",
@@ -59,26 +203,15 @@ func (h *QRCodeHandler) QRCodeHandler(w http.ResponseWriter, _ *http.Request) {
 	}
 
 	data := struct {
-		Title       string
-		GetFiles    string
-		UploadFiles string
-		Clipboard   string
-		ToQrcode    string
-		QrBase      string
+		pageData
+		QrCodes     []qrView
+		QrRefresh   int
+		Fingerprint string
 	}{
-		Title:       "QR Code",
-		GetFiles:    "/file/",
-		UploadFiles: "/upload",
-		Clipboard:   "/clipboard",
-		ToQrcode:    "/qrcode",
-		QrBase:      base64Str,
-	}
-
-	if h.BaseURI != "" {
-		data.GetFiles = h.BaseURI + "/file/"
-		data.UploadFiles = h.BaseURI + "/upload"
-		data.Clipboard = h.BaseURI + "/clipboard"
-		data.ToQrcode = h.BaseURI + "/qrcode"
+		pageData:    newPageData(r, title),
+		QrCodes:     codes,
+		QrRefresh:   refresh,
+		Fingerprint: h.Fingerprint,
 	}
 
 	err = tmpl.Execute(w, data)
@@ -86,3 +219,12 @@ func (h *QRCodeHandler) QRCodeHandler(w http.ResponseWriter, _ *http.Request) {
 		http.Error(w, "Failed to execute template: "+err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// qrPNGBase64 encodes content as a PNG QR code and returns it base64 encoded
+func qrPNGBase64(content string, size int) (string, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, size)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(png), nil
+}
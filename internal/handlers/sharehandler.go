@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	fsInternal "github.com/kumakichi/pc-mobile-file-exchanger/internal/fs"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/share"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+const shareCookiePrefix = "share_"
+
+// ShareHandler handles creating, revoking and serving share links
+type ShareHandler struct {
+	FS        fs.FS
+	Directory string
+	Store     *share.Store
+	// Limiter limits wrong share passwords per client, nil means no limit
+	Limiter       *utils.RateLimiter
+	publicPattern string
+}
+
+// NewShareHandler creates a new ShareHandler, links are served below publicPattern
+func NewShareHandler(fs fs.FS, directory string, store *share.Store, limiter *utils.RateLimiter, publicPattern string) *ShareHandler {
+	return &ShareHandler{
+		FS:            fs,
+		Directory:     directory,
+		Store:         store,
+		Limiter:       limiter,
+		publicPattern: publicPattern,
+	}
+}
+
+// shareView is a share link prepared for the management template
+type shareView struct {
+	Token       string
+	Path        string
+	IsDir       bool
+	URL         string
+	QrBase      string
+	Expires     string
+	Downloads   string
+	HasPassword bool
+	Created     bool
+}
+
+// ManageHandler lists the share links and creates new ones on POST
+func (h *ShareHandler) ManageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		h.createShare(w, r)
+		return
+	}
+
+	tmpl, err := template.ParseFS(
+		h.FS,
+		"templates/base.html",
+		"templates/sharelist.html",
+	)
+	if err != nil {
+		http.Error(w, "Failed to parse template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	created := r.URL.Query().Get("created")
+	links := h.Store.List()
+	views := make([]shareView, 0, len(links))
+	for _, link := range links {
+		if !ownsShare(r, link) {
+			continue
+		}
+		u := h.linkURL(r, link)
+		qrBase, err := qrPNGBase64(u, 160)
+		if err != nil {
+			log.Printf("Failed to generate QR code for share %s: %v", link.Token, err)
+		}
+
+		expires := "never"
+		if !link.ExpiresAt.IsZero() {
+			expires = link.ExpiresAt.Format("2006-01-02 15:04")
+		}
+		downloads := strconv.Itoa(link.Downloads)
+		if link.MaxDownloads > 0 {
+			downloads += " / " + strconv.Itoa(link.MaxDownloads)
+		}
+
+		views = append(views, shareView{
+			Token:       link.Token,
+			Path:        "/" + link.Path,
+			IsDir:       link.IsDir,
+			URL:         u,
+			QrBase:      qrBase,
+			Expires:     expires,
+			Downloads:   downloads,
+			HasPassword: link.HasPassword(),
+			Created:     link.Token == created,
+		})
+	}
+
+	data := struct {
+		pageData
+		Path   string
+		Links  []shareView
+		Revoke string
+	}{
+		pageData: newPageData(r, "Share Links"),
+		Path:     r.URL.Query().Get("path"),
+		Links:    views,
+		Revoke:   utils.RequestBaseURI(r) + "/share/revoke",
+	}
+
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		http.Error(w, "Failed to execute template: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *ShareHandler) createShare(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	root := userRoot(r, h.Directory)
+	relPath := strings.TrimPrefix(path.Clean("/"+r.FormValue("path")), "/")
+	fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(relPath)))
+	if err != nil {
+		http.Error(w, "Invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ttl time.Duration
+	if v := r.FormValue("expires"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 0 {
+			http.Error(w, "Invalid expiry", http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(minutes) * time.Minute
+	}
+
+	var maxDownloads int
+	if v := r.FormValue("max_downloads"); v != "" {
+		maxDownloads, err = strconv.Atoi(v)
+		if err != nil || maxDownloads < 0 {
+			http.Error(w, "Invalid download limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	link, err := h.Store.Create(userName(r), root, relPath, fi.IsDir(), ttl, maxDownloads, r.FormValue("password"))
+	if err != nil {
+		http.Error(w, "Failed to create share link: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Share link created for /%s", relPath)
+
+	http.Redirect(w, r, utils.RequestBaseURI(r)+"/share?created="+url.QueryEscape(link.Token), http.StatusSeeOther)
+}
+
+// RevokeHandler deletes a share link
+func (h *ShareHandler) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if link, ok := h.Store.Find(r.FormValue("token")); ok && !ownsShare(r, link) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !h.Store.Revoke(r.FormValue("token")) {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, utils.RequestBaseURI(r)+"/share", http.StatusSeeOther)
+}
+
+// ServeShare serves the file or folder behind a share link without the main credentials
+func (h *ShareHandler) ServeShare(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, h.publicPattern)
+	token := rest
+	subPath := ""
+	if idx := strings.Index(rest, "/"); idx != -1 {
+		token = rest[:idx]
+		subPath = rest[idx:]
+	}
+
+	link, err := h.Store.Get(token)
+	if err == share.ErrExhausted {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if link.HasPassword() && !h.unlocked(w, r, link) {
+		return
+	}
+
+	fullPath := filepath.Join(link.Root, filepath.FromSlash(link.Path))
+	if !link.IsDir {
+		h.serveFile(w, r, link, fullPath)
+		return
+	}
+
+	if subPath == "" {
+		http.Redirect(w, r, h.linkURL(r, link), http.StatusMovedPermanently)
+		return
+	}
+
+	fi, err := os.Stat(filepath.Join(fullPath, filepath.FromSlash(path.Clean(subPath))))
+	if err == nil && !fi.IsDir() && countsAsDownload(r) {
+		if err := h.Store.Consume(token); err != nil {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = subPath
+	http.FileServer(http.FS(fsInternal.SuffixDirFS(fullPath))).ServeHTTP(w, r2)
+}
+
+func (h *ShareHandler) serveFile(w http.ResponseWriter, r *http.Request, link share.Link, fullPath string) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	if countsAsDownload(r) {
+		if err := h.Store.Consume(link.Token); err != nil {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		log.Printf("Serving shared file: /%s", link.Path)
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(fi.Name()))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// countsAsDownload reports whether r starts a download of a whole file. HEAD
+// requests and ranges resuming or seeking within a download are not counted
+func countsAsDownload(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	rng := strings.TrimSpace(r.Header.Get("Range"))
+	return rng == "" || strings.HasPrefix(rng, "bytes=0-")
+}
+
+// unlocked checks the password of link, asking for it when needed
+func (h *ShareHandler) unlocked(w http.ResponseWriter, r *http.Request, link share.Link) bool {
+	cookieName := shareCookiePrefix + link.Token
+	if c, err := r.Cookie(cookieName); err == nil && h.Store.CheckAccessKey(link, c.Value) {
+		return true
+	}
+
+	wrongPassword := false
+	if r.Method == http.MethodPost {
+		ip := utils.RemoteIP(r)
+		if h.Limiter != nil && h.Limiter.Exhausted(ip) {
+			http.Error(w, "Too many wrong passwords, try again later", http.StatusTooManyRequests)
+			return false
+		}
+		if h.Store.CheckPassword(link, r.FormValue("password")) {
+			// the cookie path includes the base path the app is mounted under
+			cookiePath := h.publicPattern + link.Token
+			if base, err := url.Parse(utils.RequestBaseURI(r)); err == nil {
+				cookiePath = base.Path + cookiePath
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     cookieName,
+				Value:    h.Store.AccessKey(link),
+				Path:     cookiePath,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, utils.RequestBaseURI(r)+r.URL.EscapedPath(), http.StatusSeeOther)
+			return false
+		}
+		log.Printf("Wrong share password from %s", ip)
+		if h.Limiter != nil {
+			h.Limiter.Take(ip)
+		}
+		wrongPassword = true
+	}
+
+	tmpl, err := template.ParseFS(h.FS, "templates/sharepassword.html")
+	if err != nil {
+		http.Error(w, "Failed to parse template: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	data := struct {
+		Title         string
+		Base          string
+		Name          string
+		WrongPassword bool
+	}{
+		Title:         "Protected Share",
+		Base:          utils.RequestBaseURI(r),
+		Name:          path.Base("/" + link.Path),
+		WrongPassword: wrongPassword,
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("Failed to execute template: %v", err)
+	}
+	return false
+}
+
+// ownsShare reports whether the user of r may see and revoke link
+func ownsShare(r *http.Request, link share.Link) bool {
+	u := auth.UserFromRequest(r)
+	return u == nil || u.Can(auth.PermAdmin) || u.User == link.Owner
+}
+
+// linkURL returns the absolute URL of link, with a trailing slash for folders
+func (h *ShareHandler) linkURL(r *http.Request, link share.Link) string {
+	u := utils.RequestBaseURI(r) + h.publicPattern + link.Token
+	if link.IsDir {
+		u += "/"
+	}
+	return u
+}
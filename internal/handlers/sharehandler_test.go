@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/share"
+)
+
+func TestShareDownloadCount(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "folder"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "folder/b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		isDir bool
+		file  string
+	}{
+		{name: "file", path: "a.txt"},
+		{name: "folder", path: "folder", isDir: true, file: "/b.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := share.NewStore()
+			if err != nil {
+				t.Fatalf("NewStore: %v", err)
+			}
+			link, err := store.Create("", dir, tt.path, tt.isDir, 0, 1, "")
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			h := NewShareHandler(nil, dir, store, nil, "/s/")
+
+			requests := []struct {
+				method string
+				rng    string
+				want   int
+			}{
+				{method: http.MethodHead, want: http.StatusOK},
+				{method: http.MethodGet, rng: "bytes=4-", want: http.StatusPartialContent},
+				{method: http.MethodHead, want: http.StatusOK},
+				// the whole file is the one counted download
+				{method: http.MethodGet, rng: "bytes=0-", want: http.StatusPartialContent},
+				{method: http.MethodGet, want: http.StatusGone},
+			}
+			for _, req := range requests {
+				r := httptest.NewRequest(req.method, "/s/"+link.Token+tt.file, nil)
+				if req.rng != "" {
+					r.Header.Set("Range", req.rng)
+				}
+				w := httptest.NewRecorder()
+				h.ServeShare(w, r)
+				if w.Code != req.want {
+					t.Errorf("%s %q = %d, want %d", req.method, req.rng, w.Code, req.want)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
@@ -14,21 +15,19 @@ import (
 // UploadHandler handles file upload requests
 type UploadHandler struct {
 	FS        fs.FS
-	BaseURI   string
 	UploadDir string
 }
 
 // NewUploadHandler creates a new UploadHandler
-func NewUploadHandler(fs fs.FS, baseURI, uploadDir string) *UploadHandler {
+func NewUploadHandler(fs fs.FS, uploadDir string) *UploadHandler {
 	return &UploadHandler{
 		FS:        fs,
-		BaseURI:   baseURI,
 		UploadDir: uploadDir,
 	}
 }
 
 // UploadFormHandler serves the upload form
-func (h *UploadHandler) UploadFormHandler(w http.ResponseWriter, _ *http.Request) {
+func (h *UploadHandler) UploadFormHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl, err := template.ParseFS(
 		h.FS,
 		"templates/base.html",
@@ -39,26 +38,7 @@ func (h *UploadHandler) UploadFormHandler(w http.ResponseWriter, _ *http.Request
 		return
 	}
 
-	data := struct {
-		Title       string
-		GetFiles    string
-		UploadFiles string
-		Clipboard   string
-		ToQrcode    string
-	}{
-		Title:       "Upload Files",
-		GetFiles:    "/file/",
-		UploadFiles: "/upload",
-		Clipboard:   "/clipboard",
-		ToQrcode:    "/qrcode",
-	}
-
-	if h.BaseURI != "" {
-		data.GetFiles = h.BaseURI + "/file/"
-		data.UploadFiles = h.BaseURI + "/upload"
-		data.Clipboard = h.BaseURI + "/clipboard"
-		data.ToQrcode = h.BaseURI + "/qrcode"
-	}
+	data := newPageData(r, "Upload Files")
 
 	err = tmpl.Execute(w, data)
 	if err != nil {
@@ -89,12 +69,11 @@ func (h *UploadHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	failedFiles := make([]string, 0)
 
 	// Ensure upload directory exists
-	if _, err := os.Stat(h.UploadDir); os.IsNotExist(err) {
-		err = os.MkdirAll(h.UploadDir, 0755)
-		if err != nil {
-			http.Error(w, "Failed to create upload directory: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	uploadDir := userUploadDir(r, h.UploadDir)
+	err = ensureDir(uploadDir)
+	if err != nil {
+		http.Error(w, "Failed to create upload directory: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	for _, fileHeader := range files {
@@ -106,17 +85,7 @@ func (h *UploadHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
-		// Create destination file
-		dst, err := os.Create(filepath.Join(h.UploadDir, fileHeader.Filename))
-		if err != nil {
-			failedFiles = append(failedFiles, fileHeader.Filename)
-			log.Printf("Failed to create destination file: %v", err)
-			continue
-		}
-		defer dst.Close()
-
-		// Copy the file content
-		_, err = io.Copy(dst, file)
+		_, err = saveToDir(uploadDir, fileHeader.Filename, file)
 		if err != nil {
 			failedFiles = append(failedFiles, fileHeader.Filename)
 			log.Printf("Failed to save uploaded file: %v", err)
@@ -138,23 +107,15 @@ func (h *UploadHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := struct {
-		Title       string
-		GetFiles    string
-		UploadFiles string
-		Clipboard   string
-		ToQrcode    string
+		pageData
 		OkFiles     string
 		FailedFiles string
 		FilePath    string
 	}{
-		Title:       "Upload Result",
-		GetFiles:    h.BaseURI + "/file/",
-		UploadFiles: h.BaseURI + "/upload",
-		Clipboard:   h.BaseURI + "/clipboard",
-		ToQrcode:    h.BaseURI + "/qrcode",
+		pageData:    newPageData(r, "Upload Result"),
 		OkFiles:     strings.Join(okFiles, ", "),
 		FailedFiles: strings.Join(failedFiles, ", "),
-		FilePath:    h.UploadDir,
+		FilePath:    uploadDir,
 	}
 
 	err = tmpl.Execute(w, data)
@@ -162,3 +123,34 @@ func (h *UploadHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to execute template: "+err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// ensureDir creates dir when it does not exist yet
+func ensureDir(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return os.MkdirAll(dir, 0755)
+	}
+	return nil
+}
+
+// saveToDir writes src to a file called name inside dir and returns its
+// path. Only the last element of name is used so the file cannot end up
+// outside dir.
+func saveToDir(dir, name string, src io.Reader) (string, error) {
+	base := filepath.Base(filepath.Clean(name))
+	if base == "." || base == ".." || base == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+
+	fullPath := filepath.Join(dir, base)
+	dst, err := os.Create(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("create err: %w", err)
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return "", fmt.Errorf("copy err: %w", err)
+	}
+	return fullPath, dst.Close()
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+)
+
+// userName returns the name of the user of r, empty when authentication is disabled
+func userName(r *http.Request) string {
+	if u := auth.UserFromRequest(r); u != nil {
+		return u.User
+	}
+	return ""
+}
+
+// userRoot returns the shared directory of the user of r, dir if it has none
+func userRoot(r *http.Request, dir string) string {
+	if u := auth.UserFromRequest(r); u != nil && u.Root != "" {
+		return u.Root
+	}
+	return dir
+}
+
+// userUploadDir returns the upload directory of the user of r, dir if it has none
+func userUploadDir(r *http.Request, dir string) string {
+	if u := auth.UserFromRequest(r); u != nil && u.UploadDir != "" {
+		return u.UploadDir
+	}
+	return dir
+}
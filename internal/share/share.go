@@ -0,0 +1,199 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
+)
+
+var (
+	// ErrNotFound is returned when a token does not match any usable link
+	ErrNotFound = errors.New("share link not found or expired")
+	// ErrExhausted is returned when a link reached its download limit
+	ErrExhausted = errors.New("share link download limit reached")
+)
+
+// Link is a tokenized share of a single file or folder
+type Link struct {
+	Token        string
+	Owner        string
+	Root         string
+	Path         string
+	IsDir        bool
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	MaxDownloads int
+	Downloads    int
+	passwordHash string
+}
+
+// HasPassword reports whether the link is protected by a password
+func (l Link) HasPassword() bool {
+	return l.passwordHash != ""
+}
+
+// Expired reports whether the link is no longer valid at t
+func (l Link) Expired(t time.Time) bool {
+	return !l.ExpiresAt.IsZero() && t.After(l.ExpiresAt)
+}
+
+// Exhausted reports whether the link reached its download limit
+func (l Link) Exhausted() bool {
+	return l.MaxDownloads > 0 && l.Downloads >= l.MaxDownloads
+}
+
+// Store keeps share links in memory
+type Store struct {
+	links  map[string]*Link
+	secret []byte
+	mutex  sync.RWMutex
+}
+
+// NewStore creates an empty Store
+func NewStore() (*Store, error) {
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		links:  make(map[string]*Link),
+		secret: []byte(secret),
+	}, nil
+}
+
+// Create adds a new link of owner for path inside root, ttl <= 0 means the
+// link never expires
+func (s *Store) Create(owner, root, path string, isDir bool, ttl time.Duration, maxDownloads int, password string) (Link, error) {
+	token, err := utils.RandomToken(12)
+	if err != nil {
+		return Link{}, err
+	}
+
+	now := time.Now()
+	link := &Link{
+		Token:        token,
+		Owner:        owner,
+		Root:         root,
+		Path:         path,
+		IsDir:        isDir,
+		CreatedAt:    now,
+		MaxDownloads: maxDownloads,
+	}
+	if ttl > 0 {
+		link.ExpiresAt = now.Add(ttl)
+	}
+	if password != "" {
+		link.passwordHash = hashPassword(token, password)
+	}
+
+	s.mutex.Lock()
+	s.links[token] = link
+	s.mutex.Unlock()
+	return *link, nil
+}
+
+// Get returns the link for token if it is still usable
+func (s *Store) Get(token string) (Link, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	link, ok := s.links[token]
+	if !ok || link.Expired(time.Now()) {
+		return Link{}, ErrNotFound
+	}
+	if link.Exhausted() {
+		return Link{}, ErrExhausted
+	}
+	return *link, nil
+}
+
+// Find returns the link for token even when it is expired or exhausted
+func (s *Store) Find(token string) (Link, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	link, ok := s.links[token]
+	if !ok {
+		return Link{}, false
+	}
+	return *link, true
+}
+
+// Consume counts one download against the link for token
+func (s *Store) Consume(token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	link, ok := s.links[token]
+	if !ok || link.Expired(time.Now()) {
+		return ErrNotFound
+	}
+	if link.Exhausted() {
+		return ErrExhausted
+	}
+	link.Downloads++
+	return nil
+}
+
+// Revoke removes the link for token
+func (s *Store) Revoke(token string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.links[token]
+	delete(s.links, token)
+	return ok
+}
+
+// List drops expired links and returns the rest, newest first
+func (s *Store) List() []Link {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	links := make([]Link, 0, len(s.links))
+	for token, link := range s.links {
+		if link.Expired(now) {
+			delete(s.links, token)
+			continue
+		}
+		links = append(links, *link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.After(links[j].CreatedAt)
+	})
+	return links
+}
+
+// CheckPassword reports whether password unlocks the link
+func (s *Store) CheckPassword(link Link, password string) bool {
+	if !link.HasPassword() {
+		return true
+	}
+	expected := hashPassword(link.Token, password)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(link.passwordHash)) == 1
+}
+
+// AccessKey returns the cookie value proving the password of link was entered
+func (s *Store) AccessKey(link Link) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(link.Token + ":" + link.passwordHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckAccessKey validates a value previously returned by AccessKey
+func (s *Store) CheckAccessKey(link Link, key string) bool {
+	return hmac.Equal([]byte(s.AccessKey(link)), []byte(key))
+}
+
+func hashPassword(token, password string) string {
+	sum := sha256.Sum256([]byte(token + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
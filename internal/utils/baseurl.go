@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type baseURIKey struct{}
+
+type remoteIPKey struct{}
+
+// BaseURL works out the absolute URL the app is reached at, per request
+type BaseURL struct {
+	// External is the public URL of the app, replacing whatever the request says
+	External string
+	// BasePath is the path the app is mounted under, empty for the root
+	BasePath string
+	// TrustProxy honours the X-Forwarded-Proto, X-Forwarded-Host,
+	// X-Forwarded-Prefix and X-Forwarded-For headers set by a reverse proxy
+	TrustProxy bool
+}
+
+// NewBaseURL checks external and basePath and creates a BaseURL from them
+func NewBaseURL(external, basePath string, trustProxy bool) (*BaseURL, error) {
+	if external != "" {
+		u, err := url.Parse(external)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid external URL %q", external)
+		}
+		external = strings.TrimRight(u.String(), "/")
+	}
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		return nil, fmt.Errorf("base path %q must start with /", basePath)
+	}
+	return &BaseURL{
+		External:   external,
+		BasePath:   basePath,
+		TrustProxy: trustProxy,
+	}, nil
+}
+
+// For returns the base URI of r without a trailing slash, like
+// https://example.com/files
+func (b *BaseURL) For(r *http.Request) string {
+	if b.External != "" {
+		return b.External
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	prefix := ""
+	if b.TrustProxy {
+		if proto := firstValue(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := firstValue(r.Header.Get("X-Forwarded-Host")); fwdHost != "" {
+			host = fwdHost
+		}
+		if fwdPrefix := strings.TrimRight(firstValue(r.Header.Get("X-Forwarded-Prefix")), "/"); strings.HasPrefix(fwdPrefix, "/") {
+			prefix = fwdPrefix
+		}
+	}
+	return scheme + "://" + host + prefix + b.BasePath
+}
+
+// Handler strips BasePath from the request path before passing it to next,
+// and keeps the base URI of the request for RequestBaseURI. With TrustProxy
+// the client address from X-Forwarded-For is kept for RemoteIP. Requests
+// outside BasePath get a 404
+func (b *BaseURL) Handler(next http.Handler) http.Handler {
+	if b.BasePath != "" {
+		next = http.StripPrefix(b.BasePath, next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), baseURIKey{}, b.For(r))
+		if b.TrustProxy {
+			if ip := forwardedFor(r.Header.Values("X-Forwarded-For")); ip != "" {
+				ctx = context.WithValue(ctx, remoteIPKey{}, ip)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestBaseURI returns the base URI links in the response to r start with
+func RequestBaseURI(r *http.Request) string {
+	if base, ok := r.Context().Value(baseURIKey{}).(string); ok {
+		return base
+	}
+	return (&BaseURL{}).For(r)
+}
+
+// forwardedFor returns the last address in the X-Forwarded-For values, the
+// one the proxy in front of the app added. Earlier ones come from the client
+// and may be forged
+func forwardedFor(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	list := strings.Split(values[len(values)-1], ",")
+	ip := net.ParseIP(strings.TrimSpace(list[len(list)-1]))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// firstValue returns the first entry of a comma separated header value
+func firstValue(v string) string {
+	if idx := strings.Index(v, ","); idx != -1 {
+		v = v[:idx]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	certLifetime = 365 * 24 * time.Hour
+	// certRenewBefore is how long before its expiry a cached certificate is replaced
+	certRenewBefore = 30 * 24 * time.Hour
+)
+
+// SelfSignedCert returns the certificate and key files in dir for hosts,
+// reusing the cached pair while it covers every host and is not about to
+// expire, and generating a new self-signed pair otherwise
+func SelfSignedCert(dir string, hosts []string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && certCovers(cert, hosts) {
+		return certFile, keyFile, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", fmt.Errorf("create certificate dir err: %w", err)
+	}
+	certPEM, keyPEM, err := generateCert(hosts)
+	if err != nil {
+		return "", "", fmt.Errorf("generate certificate err: %w", err)
+	}
+	if err := WriteFileAtomic(keyFile, keyPEM, 0600); err != nil {
+		return "", "", fmt.Errorf("write key err: %w", err)
+	}
+	if err := WriteFileAtomic(certFile, certPEM, 0644); err != nil {
+		return "", "", fmt.Errorf("write certificate err: %w", err)
+	}
+	return certFile, keyFile, nil
+}
+
+// CertFingerprint returns the SHA-256 fingerprint of the first certificate
+// in certFile, as colon separated hex bytes
+func CertFingerprint(certFile string) (string, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", fmt.Errorf("read certificate err: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate in %s", certFile)
+	}
+
+	sum := sha256.Sum256(block.Bytes)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// certCovers reports whether cert is a leaf valid for every host for a while
+// longer, CA certificates cached by earlier versions are replaced
+func certCovers(cert tls.Certificate, hosts []string) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil || leaf.IsCA {
+		return false
+	}
+	if time.Now().Add(certRenewBefore).After(leaf.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateCert creates a self-signed leaf certificate for hosts, which cannot
+// sign other certificates, and returns it and its key PEM encoded
+func generateCert(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"pc-mobile-file-exchanger"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
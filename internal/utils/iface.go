@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InterfaceKind is a guess at what a network interface connects to
+type InterfaceKind string
+
+const (
+	KindEthernet InterfaceKind = "ethernet"
+	KindWireless InterfaceKind = "wireless"
+	KindVirtual  InterfaceKind = "virtual"
+	KindTunnel   InterfaceKind = "tunnel"
+	KindUnknown  InterfaceKind = "unknown"
+)
+
+var (
+	tunnelPrefixes  = []string{"tun", "tap", "utun", "wg", "ppp", "ipsec", "tailscale", "zt", "nordlynx", "gpd"}
+	virtualPrefixes = []string{"docker", "br-", "veth", "virbr", "vmnet", "vboxnet", "lxc", "lxd", "cni", "flannel", "cali", "podman", "vEthernet", "bridge", "awdl", "llw"}
+	wirelessNames   = []string{"wl", "wifi", "ath"}
+	ethernetNames   = []string{"eth", "en"}
+)
+
+// physical reports whether k is a real network card
+func (k InterfaceKind) physical() bool {
+	return k == KindEthernet || k == KindWireless
+}
+
+// virtual reports whether k only reaches containers, VMs or a VPN
+func (k InterfaceKind) virtual() bool {
+	return k == KindVirtual || k == KindTunnel
+}
+
+// interfaceKind guesses the kind of i from its flags, its name and, on
+// Linux, from /sys/class/net
+func interfaceKind(i net.Interface) InterfaceKind {
+	switch {
+	case i.Flags&net.FlagPointToPoint != 0 || hasPrefix(i.Name, tunnelPrefixes):
+		return KindTunnel
+	case hasPrefix(i.Name, virtualPrefixes):
+		return KindVirtual
+	}
+
+	sys := filepath.Join("/sys/class/net", i.Name)
+	if _, err := os.Stat(sys); err == nil {
+		if exists(filepath.Join(sys, "wireless")) || exists(filepath.Join(sys, "phy80211")) {
+			return KindWireless
+		}
+		if !exists(filepath.Join(sys, "device")) {
+			return KindVirtual
+		}
+		return KindEthernet
+	}
+
+	switch {
+	case hasPrefix(i.Name, wirelessNames):
+		return KindWireless
+	case hasPrefix(i.Name, ethernetNames):
+		return KindEthernet
+	}
+	return KindUnknown
+}
+
+// defaultRouteIPs returns the local addresses the system uses to reach the
+// internet. Connecting a UDP socket only picks a route, it sends nothing
+func defaultRouteIPs() []net.IP {
+	var ips []net.IP
+	for _, target := range []string{"8.8.8.8:53", "[2001:4860:4860::8888]:53"} {
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			continue
+		}
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			ips = append(ips, addr.IP)
+		}
+		conn.Close()
+	}
+	return ips
+}
+
+// isPrivateIP reports whether ip is in a private IPv4 range or an IPv6
+// unique local address, the usual addresses of a home or office LAN
+func isPrivateIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4[0] == 10 ||
+			(ip4[0] == 172 && ip4[1]&0xf0 == 16) ||
+			(ip4[0] == 192 && ip4[1] == 168)
+	}
+	return len(ip) == net.IPv6len && ip[0]&0xfe == 0xfc
+}
+
+func hasPrefix(name string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
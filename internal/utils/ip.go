@@ -1,19 +1,73 @@
 package utils
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 )
 
-// GetIPs retrieves all IP addresses from network interfaces
-func GetIPs() map[string]string {
+// Address is an IP address of a network interface and what is known about
+// that interface
+type Address struct {
+	Interface string
+	IP        net.IP
+	Flags     net.Flags
+	Kind      InterfaceKind
+	// DefaultRoute is set on the interface the system reaches the internet through
+	DefaultRoute bool
+}
+
+// Up reports whether the interface of a is up
+func (a Address) Up() bool {
+	return a.Flags&net.FlagUp != 0
+}
+
+// Reachable reports whether phones on the LAN likely reach a: its interface
+// is up and neither virtual nor a tunnel, and the IP is not link-local
+func (a Address) Reachable() bool {
+	return a.Up() && !a.Kind.virtual() && !a.IP.IsLinkLocalUnicast()
+}
+
+// Host returns the IP for dialing or listening, link-local IPv6 addresses
+// carry their interface as zone, like fe80::1%eth0
+func (a Address) Host() string {
+	if a.IP.To4() == nil && a.IP.IsLinkLocalUnicast() && a.Interface != "" {
+		return a.IP.String() + "%" + a.Interface
+	}
+	return a.IP.String()
+}
+
+// URLHost returns ip:port as used in URLs, with brackets around IPv6
+// addresses. The zone is left out, it means nothing to other machines
+func (a Address) URLHost(port int) string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(port))
+}
+
+func (a Address) String() string {
+	hints := []string{string(a.Kind)}
+	if !a.Up() {
+		hints = append(hints, "down")
+	}
+	if a.DefaultRoute {
+		hints = append(hints, "default route")
+	}
+	return a.Interface + "\t" + a.Host() + "\t(" + strings.Join(hints, ", ") + ")"
+}
+
+// GetAddresses returns every unicast address of all network interfaces
+// except loopback, the likely LAN address first
+func GetAddresses() []Address {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		log.Fatal(err)
 	}
-	ips := make(map[string]string, len(ifaces))
 
+	routeIPs := defaultRouteIPs()
+	var list []Address
 	for _, i := range ifaces {
 		addrs, err := i.Addrs()
 		if err != nil {
@@ -21,24 +75,127 @@ func GetIPs() map[string]string {
 			continue
 		}
 
+		kind := interfaceKind(i)
+		first := len(list)
+		defaultRoute := false
 		for _, a := range addrs {
-			if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-				if ipnet.IP.To4() != nil {
-					ips[i.Name] = ipnet.IP.String()
-				}
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsMulticast() || ipnet.IP.IsUnspecified() {
+				continue
+			}
+			for _, ip := range routeIPs {
+				defaultRoute = defaultRoute || ip.Equal(ipnet.IP)
 			}
+			list = append(list, Address{Interface: i.Name, IP: ipnet.IP, Flags: i.Flags, Kind: kind})
+		}
+		for j := first; j < len(list); j++ {
+			list[j].DefaultRoute = defaultRoute
 		}
 	}
 
-	return ips
+	sort.SliceStable(list, func(i, j int) bool {
+		return preferred(list[i], list[j])
+	})
+	return list
+}
+
+// preferred reports whether phones on the LAN more likely reach a than b:
+// up before down, physical before virtual interfaces, then the default
+// route, IPv4, private and global addresses
+func preferred(a, b Address) bool {
+	keys := [][2]bool{
+		{a.Up(), b.Up()},
+		{a.Kind.physical(), b.Kind.physical()},
+		{!a.Kind.virtual(), !b.Kind.virtual()},
+		{a.DefaultRoute, b.DefaultRoute},
+		{a.IP.To4() != nil, b.IP.To4() != nil},
+		{isPrivateIP(a.IP), isPrivateIP(b.IP)},
+		{!a.IP.IsLinkLocalUnicast(), !b.IP.IsLinkLocalUnicast()},
+	}
+	for _, k := range keys {
+		if k[0] != k[1] {
+			return k[0]
+		}
+	}
+	return a.Interface < b.Interface
 }
 
-// Keys returns the keys of a map as a sorted slice
-func Keys(m map[string]string) []string {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
+// ParseAddresses picks the entries of spec from all, spec is a comma
+// separated list of interface names and IP addresses. IPs that are not in
+// all, like 127.0.0.1, are accepted as they are
+func ParseAddresses(spec string, all []Address) ([]Address, error) {
+	var list []Address
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		found := false
+		for _, a := range all {
+			if a.Interface == entry {
+				list = append(list, a)
+				found = true
+			}
+		}
+		if found {
+			continue
+		}
+
+		host, zone := entry, ""
+		if idx := strings.Index(entry, "%"); idx != -1 {
+			host, zone = entry[:idx], entry[idx+1:]
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is neither an interface nor an IP address", entry)
+		}
+		addr := Address{Interface: zone, IP: ip}
+		for _, a := range all {
+			if a.IP.Equal(ip) && (zone == "" || zone == a.Interface) {
+				addr = a
+				break
+			}
+		}
+		list = append(list, addr)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no address in %q", spec)
+	}
+	return list, nil
+}
+
+// RemoteIP returns the client address of r without the port, behind a
+// trusted proxy the one it forwarded the request for
+func RemoteIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(remoteIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IsLocalIP reports whether ip is a loopback address or one of the
+// addresses of this machine
+func IsLocalIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
 	}
-	sort.Strings(keys)
-	return keys
+	return false
 }
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// WriteQRCodeSVG writes qr as a size x size pixel SVG image, each dark
+// module becomes one unit square of a single path
+func WriteQRCodeSVG(w io.Writer, qr *qrcode.QRCode, size int) error {
+	bitmap := qr.Bitmap()
+	modules := len(bitmap)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, modules, modules)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="#ffffff"/><path fill="#000000" d="`, modules, modules)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(bw, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	fmt.Fprint(bw, `"/></svg>`)
+	return bw.Flush()
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	ansiBlackFg = 30
+	ansiWhiteFg = 97
+	ansiBlackBg = 40
+	ansiWhiteBg = 107
+)
+
+// PrintQRCode writes content as a QR code drawn with Unicode half blocks,
+// two modules per character. Colors are set explicitly so the code scans on
+// both dark and light terminal themes.
+func PrintQRCode(w io.Writer, content string) error {
+	qr, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bitmap := qr.Bitmap()
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			fg, bg := ansiWhiteFg, ansiWhiteBg
+			if bitmap[y][x] {
+				fg = ansiBlackFg
+			}
+			if y+1 < len(bitmap) && bitmap[y+1][x] {
+				bg = ansiBlackBg
+			}
+			fmt.Fprintf(bw, "\x1b[%d;%dm▀", fg, bg)
+		}
+		fmt.Fprint(bw, "\x1b[0m\n")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per key, e.g. per client IP
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	mutex   sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter refilling perMinute tokens a minute
+// up to burst tokens
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Exhausted reports whether key has no token left
+func (l *RateLimiter) Exhausted(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.refill(key, time.Now()).tokens < 1
+}
+
+// Take removes one token from the bucket of key
+func (l *RateLimiter) Take(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	b := l.refill(key, now)
+	if b.tokens >= 1 {
+		b.tokens--
+	}
+
+	// forget full buckets now and then to keep the map small
+	if len(l.buckets) > 1024 {
+		for k := range l.buckets {
+			if k != key && l.refill(k, now).tokens >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+}
+
+// refill returns the bucket of key topped up until now, the caller holds the lock
+func (l *RateLimiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	return b
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"embed"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/clipboard"
 	fsInternal "github.com/kumakichi/pc-mobile-file-exchanger/internal/fs"
 	"github.com/kumakichi/pc-mobile-file-exchanger/internal/handlers"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/share"
 	"github.com/kumakichi/pc-mobile-file-exchanger/internal/utils"
 	"github.com/skratchdot/open-golang/open"
 )
@@ -33,8 +40,22 @@ const (
 	filePattern      = "/file/"
 	uploadPattern    = "/upload"
 	clipboardPattern = "/clipboard"
+	sharePattern     = "/share"
+	sharedPattern    = "/s/"
+	pairPattern      = "/pair"
+	loginPattern     = "/login"
+	logoutPattern    = "/logout"
+	bansPattern      = "/bans"
+	devicePattern    = "/device"
+	devicesPattern   = "/devices"
 )
 
+// sharePasswordRate is how many wrong share passwords a client may send a minute
+const sharePasswordRate = 5
+
+// passwordEnv names the environment variable that may hold the password
+const passwordEnv = "PMFE_PASSWORD"
+
 var (
 	Version           = "unknown"
 	directory         string
@@ -48,11 +69,34 @@ var (
 	filterSuffix      string
 	authUser          string
 	authPwd           string
+	authPwdFile       string
+	usersFile         string
+	routesVar         string
 	banTimeoutVar     int
 	banCountVar       int
 	serverKey         string
 	serverCrt         string
+	autoTLS           bool
+	externalURL       string
+	basePath          string
+	trustProxy        bool
 	netInterfaceIndex int
+	listenVar         string
+	pairTimeoutVar    int
+	sessionTTL        int
+	banFile           string
+	banAllow          string
+	devicesFile       string
+	terminalQRCode    bool
+	clipboardFile     string
+	clipboardTTL      int
+	clipboardMax      int
+	codeLength        int
+	codeAlphabet      string
+	codeWords         bool
+	retrieveRate      int
+	attachmentSize    int
+	hostClipboard     bool
 )
 
 func init() {
@@ -62,54 +106,205 @@ func init() {
 	flag.StringVar(&upDirectory, "ud", "./", "directory for uploading files")
 	flag.BoolVar(&noAuth, "na", false, "no authentication")
 	flag.BoolVar(&noQRCode, "nq", false, "no QRCode page")
+	flag.BoolVar(&terminalQRCode, "tq", false, "print QRCode in terminal, useful on headless machines")
 	flag.BoolVar(&patchHTMLToParent, "pp", false, "patch html file with parent links")
 	flag.StringVar(&filterSuffix, "fs", "", "filter by suffix, empty means do not filter")
 	flag.StringVar(&authUser, "au", "admin", "username for basic auth")
-	flag.StringVar(&authPwd, "ap", "admin", "password for basic auth")
-	flag.IntVar(&banTimeoutVar, "banTimeout", 300, "timeout for auto ban")
-	flag.IntVar(&banCountVar, "banCount", 3, "count for auto ban")
+	flag.StringVar(&authPwd, "ap", "admin", "password for basic auth, visible to other local users, prefer -apFile or $"+passwordEnv)
+	flag.StringVar(&usersFile, "users", "", "JSON file of users with permissions (download, upload, clipboard, admin) and optional root and upload_dir, replaces -au and -ap")
+	flag.StringVar(&routesVar, "routes", "", "comma separated route policies overriding the defaults, e.g. /upload=public,/qrcode=localhost,/clipboard=role:admin; "+
+		"policies are public, authenticated, localhost and role:download|upload|clipboard|admin")
+	flag.StringVar(&authPwdFile, "apFile", "", "file whose first line is the password or a bcrypt hash from the hash-password command")
+	flag.IntVar(&banTimeoutVar, "banTimeout", 300, "seconds an IP is banned for at first, doubling with each further ban")
+	flag.IntVar(&banCountVar, "banCount", 3, "failed logins within banTimeout that get an IP banned, 0 disables banning")
 	flag.StringVar(&serverKey, "key", "", "server key")
 	flag.StringVar(&serverCrt, "crt", "", "server cert")
-	flag.IntVar(&netInterfaceIndex, "nic", -1, "network interface index, use -1 to choose interactively")
+	flag.StringVar(&externalURL, "externalURL", "", "public URL of the app behind a reverse proxy, e.g. https://files.example.com/pmfe, used for every link and QR code")
+	flag.StringVar(&basePath, "base-path", "", "path prefix the app is served under, e.g. /pmfe")
+	flag.BoolVar(&trustProxy, "trustProxy", false, "build links from the X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix headers of a reverse proxy and take the client address from X-Forwarded-For")
+	flag.BoolVar(&autoTLS, "tls", false, "serve HTTPS with a self-signed certificate for the LAN IP, cached in the user config dir, unless -key and -crt are given")
+	flag.IntVar(&netInterfaceIndex, "nic", -1, "index of the address to listen on, in the order offered interactively with the likely LAN address first, use -1 to choose interactively")
+	flag.StringVar(&listenVar, "listen", "", "listen on all addresses with \"all\", or on a comma separated list of interfaces and IPs like wlan0,usb0,fe80::1%eth0; replaces -nic")
+	flag.StringVar(&clipboardFile, "cbFile", "", "JSON file keeping clipboard entries across restarts, empty means memory only")
+	flag.IntVar(&clipboardTTL, "cbTTL", 1440, "minutes a clipboard entry is kept, 0 means forever")
+	flag.IntVar(&clipboardMax, "cbMax", 1000, "maximum number of clipboard entries, 0 means no limit")
+	flag.IntVar(&codeLength, "cbCodeLen", 4, "length of clipboard codes")
+	flag.StringVar(&codeAlphabet, "cbCodeChars", clipboard.DefaultAlphabet, "characters clipboard codes are made of")
+	flag.BoolVar(&codeWords, "cbWords", false, "use clipboard codes made of words, like blue-tiger-42")
+	flag.IntVar(&retrieveRate, "cbRetrieveRate", 10, "invalid clipboard codes allowed per minute and client, 0 means no limit")
+	flag.IntVar(&attachmentSize, "cbMaxSize", 10, "maximum size in MB of a clipboard attachment")
+	flag.BoolVar(&hostClipboard, "hostClip", false, "let admin devices read and write the PC clipboard through wl-clipboard, xclip or xsel")
+	flag.IntVar(&pairTimeoutVar, "pairTimeout", 120, "seconds a one-time login QR code stays valid")
+	flag.StringVar(&banFile, "banFile", "", "JSON file keeping the ban list and allowlist across restarts, empty means memory only")
+	flag.StringVar(&banAllow, "banAllow", "", "comma separated IPs or CIDRs that are never banned, e.g. 192.168.1.23,10.0.0.0/8")
+	flag.StringVar(&devicesFile, "devicesFile", "", "JSON file keeping approved devices across restarts, empty means memory only")
+	flag.IntVar(&sessionTTL, "sessionTTL", 1440, "minutes a browser login lasts")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		hashPassword()
+		return
+	}
+
 	flag.Parse()
 	if help {
 		fmt.Printf("Usage: %s [options]\n", os.Args[0])
+		fmt.Printf("       %s hash-password < password.txt\n", os.Args[0])
 		fmt.Printf("Version: %s\n\n", Version)
 		flag.PrintDefaults()
 		return
 	}
 
-	var authString string
+	var users *auth.Users
 	if !noAuth {
-		authString = auth.CalcAuthStr(authUser, authPwd)
+		var err error
+		users, err = loadUsers()
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	ips := utils.GetIPs()
-	var ip string
-	if netInterfaceIndex >= 0 {
-		keys := utils.Keys(ips)
-		if netInterfaceIndex < len(keys) {
-			ip = ips[keys[netInterfaceIndex]]
-		} else {
+	all := utils.GetAddresses()
+	var addrs []utils.Address
+	switch {
+	case listenVar == "all":
+		addrs = all
+		if len(addrs) == 0 {
+			addrs = []utils.Address{{IP: net.IPv4(127, 0, 0, 1)}}
+		}
+	case listenVar != "":
+		var err error
+		addrs, err = utils.ParseAddresses(listenVar, all)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case netInterfaceIndex >= 0:
+		if netInterfaceIndex >= len(all) {
 			log.Fatal("Invalid network interface index.")
 		}
-	} else {
-		ip = selectInterface(ips)
+		addrs = []utils.Address{all[netInterfaceIndex]}
+	default:
+		addrs = []utils.Address{selectInterface(all)}
+	}
+
+	fingerprint := ""
+	if autoTLS && (serverKey == "" || serverCrt == "") {
+		var err error
+		serverCrt, serverKey, err = selfSignedCert(addrs)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	useTLS := serverKey != "" && serverCrt != ""
+	scheme := "http://"
+	if useTLS {
+		scheme = "https://"
+		var err error
+		fingerprint, err = utils.CertFingerprint(serverCrt)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	host := fmt.Sprintf("%s:%d", ip, port)
-	baseURI = "http://" + host
+
+	// Links in pages follow each request, baseURIs are what QR codes and the terminal show
+	baseURL, err := utils.NewBaseURL(externalURL, basePath, trustProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the server listens on every address, but only those phones likely
+	// reach get a QR code, unless there are none of them
+	shown := make([]utils.Address, 0, len(addrs))
+	for _, a := range addrs {
+		if a.Reachable() {
+			shown = append(shown, a)
+		}
+	}
+	if len(shown) == 0 {
+		shown = addrs
+	}
+	baseURIs := make([]string, 0, len(shown))
+	for _, a := range shown {
+		baseURIs = append(baseURIs, scheme+a.URLHost(port)+baseURL.BasePath)
+	}
+	if baseURL.External != "" {
+		baseURIs = []string{baseURL.External}
+	}
+	baseURI = baseURIs[0]
 
 	// Initialize file system
-	fileSystem := fsInternal.CreateFilesystemHandler(directory, filterSuffix)
+	fsInternal.SetFilterSuffix(filterSuffix)
 
 	// Initialize handlers
-	fileHandlerObj := handlers.NewFileHandler(templateFs, baseURI, directory, filterSuffix, patchHTMLToParent)
-	uploadHandler := handlers.NewUploadHandler(templateFs, baseURI, upDirectory)
-	clipboardHandler := handlers.NewClipboardHandler(templateFs, baseURI)
-	qrcodeHandler := handlers.NewQRCodeHandler(templateFs, baseURI, qrPattern)
+	fileHandlerObj := handlers.NewFileHandler(templateFs, directory, filterSuffix, patchHTMLToParent)
+	uploadHandler := handlers.NewUploadHandler(templateFs, upDirectory)
+	var clipboardStore clipboard.Store = clipboard.NewMemoryStore(clipboardMax)
+	if clipboardFile != "" {
+		fileStore, err := clipboard.NewFileStore(clipboardFile, clipboardMax)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clipboardStore = fileStore
+	}
+	clipboard.StartSweeper(clipboardStore, time.Minute)
+	var retrieveLimiter *utils.RateLimiter
+	if retrieveRate > 0 {
+		retrieveLimiter = utils.NewRateLimiter(retrieveRate, retrieveRate)
+	}
+	codes := clipboard.CodeGenerator{
+		Length:   codeLength,
+		Alphabet: codeAlphabet,
+		Words:    codeWords,
+	}
+	if codeLength < 1 {
+		log.Fatal("Clipboard code length must be at least 1.")
+	}
+	if _, err := codes.Generate(0); err != nil {
+		log.Fatal(err)
+	}
+	clipboardSyncHandler := handlers.NewClipboardSyncHandler(clipboard.NewHub(20), 1<<20)
+	clipboardFileHandler := handlers.NewClipboardFileHandler(directory, upDirectory, 1<<20)
+	clipboardHandler := handlers.NewClipboardHandler(templateFs, clipboardStore,
+		time.Duration(clipboardTTL)*time.Minute, codes, retrieveLimiter, int64(attachmentSize)<<20)
+	var sessions *auth.SessionStore
+	var pairing *auth.Pairing
+	var bans *auth.Banner
+	var devices *auth.Devices
+	if !noAuth {
+		bans = auth.NewBanner(banCountVar, time.Duration(banTimeoutVar)*time.Second)
+		if banFile != "" {
+			if err := bans.Persist(banFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		for _, addr := range strings.Split(banAllow, ",") {
+			if addr = strings.TrimSpace(addr); addr == "" {
+				continue
+			}
+			if err := bans.AllowStatic(addr); err != nil {
+				log.Fatalf("Invalid -banAllow entry %q: %v", addr, err)
+			}
+		}
+		var err error
+		sessions, err = auth.NewSessionStore(time.Duration(sessionTTL)*time.Minute, loginPattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pairing = auth.NewPairing(time.Duration(pairTimeoutVar) * time.Second)
+		devices = auth.NewDevices()
+		if devicesFile != "" {
+			if err := devices.Persist(devicesFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	qrcodeHandler := handlers.NewQRCodeHandler(templateFs, baseURIs, qrPattern, pairing, pairPattern, fingerprint)
+	shareStore, err := share.NewStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	shareHandler := handlers.NewShareHandler(templateFs, directory, shareStore,
+		utils.NewRateLimiter(sharePasswordRate, sharePasswordRate), sharedPattern)
 
 	// Set up routes
 	// Serve static files with proper MIME types
@@ -135,32 +330,68 @@ func main() {
 		}
 	})
 
-	http.Handle(qrPattern, auth.Middleware(
-		http.HandlerFunc(qrcodeHandler.QRCodeHandler), authString, noAuth, banTimeoutVar, banCountVar))
-
-	// 恢复原来的文件处理程序注册
-	http.Handle(filePattern, auth.Middleware(
-		http.StripPrefix(filePattern, fileHandlerObj.WrapFSHandler(http.FileServer(http.FS(fileSystem)))),
-		authString, noAuth, banTimeoutVar, banCountVar))
-
-	http.Handle(uploadPattern, auth.Middleware(
-		http.HandlerFunc(uploadHandler.HandleUpload),
-		authString, noAuth, banTimeoutVar, banCountVar))
-	http.Handle(clipboardPattern, auth.Middleware(
-		http.HandlerFunc(clipboardHandler.ClipboardIndexHandler),
-		authString, noAuth, banTimeoutVar, banCountVar))
-
-	http.Handle(clipboardPattern+"/generate", auth.Middleware(
-		http.HandlerFunc(clipboardHandler.GenerateClipboardCode),
-		authString, noAuth, banTimeoutVar, banCountVar))
+	// Every route goes through the guard with its default policy, unless -routes overrides it
+	routePolicies, err := auth.ParseRoutePolicies(routesVar)
+	if err != nil {
+		log.Fatal(err)
+	}
+	guard := auth.NewGuard(users, noAuth, bans, sessions, devices)
+	h := appHandlers{
+		qrcode:        qrcodeHandler,
+		file:          fileHandlerObj,
+		upload:        uploadHandler,
+		clipboard:     clipboardHandler,
+		clipboardFile: clipboardFileHandler,
+		clipboardSync: clipboardSyncHandler,
+		share:         shareHandler,
+	}
+	if hostClipboard {
+		bridge, err := clipboard.DetectBridge()
+		if err != nil {
+			log.Fatal(err)
+		}
+		h.hostClipboard = handlers.NewHostClipboardHandler(bridge, 1<<20)
+	}
+	if sessions != nil {
+		h.login = handlers.NewLoginHandler(templateFs, users, sessions, bans, devices)
+		h.ban = handlers.NewBanHandler(templateFs, bans)
+		h.device = handlers.NewDeviceHandler(templateFs, users, devices, sessions)
+	}
+	if pairing != nil {
+		h.pair = auth.PairHandler(pairing, sessions, loginPattern)
+	}
 
-	http.Handle(clipboardPattern+"/retrieve", auth.Middleware(
-		http.HandlerFunc(clipboardHandler.RetrieveClipboardContent),
-		authString, noAuth, banTimeoutVar, banCountVar))
+	for _, rt := range appRoutes(h) {
+		policy := routePolicies.Lookup(rt.pattern, rt.policy)
+		if policy != rt.policy {
+			log.Printf("Route %s is %s", rt.pattern, policy)
+		}
+		http.Handle(rt.pattern, guard.Protect(policy, rt.handler))
+	}
 
 	// Start server
-	log.Printf("Listen at %s\n", host)
-	log.Printf("Access files by http://%s%s\n", host, filePattern)
+	listeners, err := listen(addrs, listenVar == "all")
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := &http.Server{Handler: baseURL.Handler(http.DefaultServeMux)}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		log.Printf("Listen at %s\n", l.Addr())
+		go func(l net.Listener) {
+			if useTLS {
+				errs <- server.ServeTLS(l, serverCrt, serverKey)
+			} else {
+				errs <- server.Serve(l)
+			}
+		}(l)
+	}
+	for _, u := range baseURIs {
+		log.Printf("Access files by %s%s\n", u, filePattern)
+	}
+	if fingerprint != "" {
+		log.Printf("Certificate SHA-256 fingerprint %s", fingerprint)
+	}
 
 	if !noQRCode {
 		err := open.Run(baseURI + qrPattern)
@@ -169,50 +400,132 @@ func main() {
 		}
 	}
 
-	if serverKey == "" || serverCrt == "" {
-		log.Fatal(http.ListenAndServe(host, nil))
-	} else {
-		log.Fatal(http.ListenAndServeTLS(host, serverCrt, serverKey, nil))
+	if terminalQRCode {
+		owner := ""
+		if users != nil {
+			owner = users.Owner().User
+		}
+		printTerminalQRCode(qrcodeHandler, owner)
+		if pairing != nil {
+			go refreshTerminalQRCode(qrcodeHandler, owner)
+		}
+	}
+
+	log.Fatal(<-errs)
+}
+
+// loadUsers reads the -users file, or makes the -au user an admin
+func loadUsers() (*auth.Users, error) {
+	if usersFile != "" {
+		return auth.LoadUsers(usersFile)
+	}
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewUsers([]auth.User{{Credentials: creds, Permissions: []auth.Permission{auth.PermAdmin}}})
+}
+
+// loadCredentials reads the password from -apFile, $PMFE_PASSWORD or -ap, in that order
+func loadCredentials() (auth.Credentials, error) {
+	creds := auth.Credentials{User: authUser, Password: authPwd}
+	switch {
+	case authPwdFile != "":
+		pwd, err := auth.ReadPasswordFile(authPwdFile)
+		if err != nil {
+			return creds, err
+		}
+		creds.Password = pwd
+	case os.Getenv(passwordEnv) != "":
+		creds.Password = os.Getenv(passwordEnv)
+	default:
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "ap" {
+				log.Printf("Warning: -ap shows the password in the process list, use -apFile or $%s", passwordEnv)
+			}
+		})
+	}
+
+	if creds.Password == "" {
+		return creds, fmt.Errorf("empty password")
 	}
+	return creds, nil
 }
 
-func selectInterface(ips map[string]string) string {
-	length := len(ips)
+// selfSignedCert returns the certificate and key files for addrs, cached in
+// the user config dir
+func selfSignedCert(addrs []utils.Address) (string, string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", "", err
+	}
+	hosts := make([]string, 0, len(addrs)+2)
+	for _, a := range addrs {
+		hosts = append(hosts, a.IP.String())
+	}
+	hosts = append(hosts, "localhost", "127.0.0.1")
+	certFile, keyFile, err := utils.SelfSignedCert(filepath.Join(dir, "pc-mobile-file-exchanger", "tls"), hosts)
+	if err != nil {
+		return "", "", err
+	}
+	log.Printf("Using self-signed certificate %s", certFile)
+	return certFile, keyFile, nil
+}
+
+// hashPassword implements the hash-password command, printing the bcrypt
+// hash of the password read from stdin
+func hashPassword() {
+	fmt.Fprintln(os.Stderr, "Password (input is not hidden):")
+	pwd, err := auth.ReadPassword(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pwd == "" {
+		log.Fatal("Empty password.")
+	}
+
+	hash, err := auth.HashPassword(pwd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(hash)
+}
+
+func selectInterface(addrs []utils.Address) utils.Address {
+	length := len(addrs)
 	ch := make(chan int, 1)
 
 	switch {
 	case length < 1:
 		log.Println("Can not get local ip")
-		return "127.0.0.1"
+		return utils.Address{IP: net.IPv4(127, 0, 0, 1)}
 	case length == 1:
-		for _, v := range ips {
-			return v
-		}
+		return addrs[0]
 	default:
-		keys := utils.Keys(ips)
-		go readUserInput(keys, ips, ch)
+		go readUserInput(addrs, ch)
 		select {
 		case <-time.After(time.Second * 30):
 			fmt.Println()
-			log.Printf("Input timeout, using %s\t%s\n", keys[0], ips[keys[0]])
-			return ips[keys[0]]
+			log.Printf("Input timeout, using %s\n", addrs[0])
+			return addrs[0]
 		case input, ok := <-ch:
-			if ok && input >= 0 && input < len(keys) {
-				fmt.Printf("Using %s\t%s\n", keys[input], ips[keys[input]])
-				return ips[keys[input]]
+			if ok && input >= 0 && input < length {
+				fmt.Printf("Using %s\n", addrs[input])
+				return addrs[input]
 			} else {
 				log.Fatal("Invalid index.")
 			}
 		}
 	}
-	return ""
+	return utils.Address{}
 }
 
-func readUserInput(keys []string, ips map[string]string, ch chan int) {
-	for i, k := range keys {
-		fmt.Printf("(%d): %s\t%s\n", i, k, ips[k])
+func readUserInput(addrs []utils.Address, ch chan int) {
+	for i, a := range addrs {
+		fmt.Printf("(%d): %s\n", i, a)
 	}
-	fmt.Printf("Select interface by index [0-%d] ? ", len(keys)-1)
+	fmt.Printf("Select interface by index [0-%d] ? ", len(addrs)-1)
 
 	var i int
 	_, err := fmt.Scanf("%d", &i)
@@ -221,3 +534,65 @@ func readUserInput(keys []string, ips map[string]string, ch chan int) {
 	}
 	ch <- i
 }
+
+// listen opens a listener on port for every address, or a single one for
+// all addresses when listenAll is set
+func listen(addrs []utils.Address, listenAll bool) ([]net.Listener, error) {
+	hosts := make([]string, 0, len(addrs))
+	if listenAll {
+		hosts = append(hosts, "")
+	} else {
+		for _, a := range addrs {
+			hosts = append(hosts, a.Host())
+		}
+	}
+
+	listeners := make([]net.Listener, 0, len(hosts))
+	for _, host := range hosts {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// printTerminalQRCode prints the QR code, with pairing it logs in as owner
+func printTerminalQRCode(h *handlers.QRCodeHandler, owner string) {
+	u := baseURI + filePattern
+	if h.Pairing != nil {
+		var err error
+		u, err = h.PairingURL(baseURI, owner)
+		if err != nil {
+			log.Printf("Failed to create pairing token: %v", err)
+			return
+		}
+	}
+
+	err := utils.PrintQRCode(os.Stdout, u)
+	if err != nil {
+		log.Printf("Failed to print QR code: %v", err)
+		return
+	}
+	fmt.Printf("Scan to visit %s\n", u)
+	if h.Fingerprint != "" {
+		fmt.Printf("Certificate SHA-256 fingerprint %s\n", h.Fingerprint)
+	}
+	if h.Pairing != nil {
+		fmt.Printf("One-time code, valid for %s. Press Enter for a fresh one.\n", h.Pairing.TTL())
+	}
+}
+
+// refreshTerminalQRCode prints a new pairing QR code each time Enter is
+// pressed, the code printed before stops working
+func refreshTerminalQRCode(h *handlers.QRCodeHandler, owner string) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		h.Pairing.Reset(owner)
+		printTerminalQRCode(h, owner)
+	}
+}
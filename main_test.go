@@ -0,0 +1,385 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/handlers"
+)
+
+// testRemote is a client address that is not one of the PC
+const testRemote = "198.51.100.20:50000"
+
+// allRoutes returns the routes of the app with every optional feature on
+func allRoutes() []route {
+	return appRoutes(appHandlers{
+		hostClipboard: &handlers.HostClipboardHandler{},
+		login:         &handlers.LoginHandler{},
+		ban:           &handlers.BanHandler{},
+		device:        &handlers.DeviceHandler{},
+		pair:          http.NotFoundHandler(),
+	})
+}
+
+func TestAppRoutes(t *testing.T) {
+	download := auth.RolePolicy(auth.PermDownload)
+	upload := auth.RolePolicy(auth.PermUpload)
+	clip := auth.RolePolicy(auth.PermClipboard)
+	admin := auth.RolePolicy(auth.PermAdmin)
+	want := map[string]auth.Policy{
+		"/qrcode":                auth.PolicyAuthenticated,
+		"/qrcode/png":            auth.PolicyAuthenticated,
+		"/qrcode/svg":            auth.PolicyAuthenticated,
+		"/qrcode/txt":            auth.PolicyAuthenticated,
+		"/qrcode/file/":          download,
+		"/file/":                 download,
+		"/upload":                upload,
+		"/clipboard":             clip,
+		"/clipboard/generate":    clip,
+		"/clipboard/retrieve":    clip,
+		"/clipboard/history":     clip,
+		"/clipboard/api/entries": clip,
+		"/clipboard/savefile":    upload,
+		"/clipboard/loadfile":    download,
+		"/clipboard/sync":        clip,
+		"/clipboard/events":      clip,
+		"/clipboard/attachment":  clip,
+		"/clipboard/host":        admin,
+		"/share":                 download,
+		"/share/revoke":          download,
+		"/s/":                    auth.PolicyPublic,
+		"/login":                 auth.PolicyPublic,
+		"/logout":                auth.PolicyAuthenticated,
+		"/bans":                  admin,
+		"/bans/api":              admin,
+		"/bans/api/allow":        admin,
+		"/device":                auth.PolicyPublic,
+		"/devices":               admin,
+		"/devices/api":           admin,
+		"/pair":                  auth.PolicyPublic,
+	}
+
+	seen := make(map[string]bool)
+	for _, rt := range allRoutes() {
+		if seen[rt.pattern] {
+			t.Errorf("route %s registered twice", rt.pattern)
+		}
+		seen[rt.pattern] = true
+		if p, ok := want[rt.pattern]; !ok {
+			t.Errorf("route %s has no expected policy", rt.pattern)
+		} else if rt.policy != p {
+			t.Errorf("route %s is %s, want %s", rt.pattern, rt.policy, p)
+		}
+	}
+	for pattern := range want {
+		if !seen[pattern] {
+			t.Errorf("route %s is missing", pattern)
+		}
+	}
+
+	// without the optional features their routes are left out
+	for _, rt := range appRoutes(appHandlers{}) {
+		switch rt.pattern {
+		case "/clipboard/host", "/login", "/logout", "/bans", "/device", "/devices", "/pair":
+			t.Errorf("route %s registered without its handler", rt.pattern)
+		}
+	}
+}
+
+func TestAppRoutesOverrides(t *testing.T) {
+	rp, err := auth.ParseRoutePolicies("/qrcode=localhost,/clipboard=role:admin,/clipboard/host=public,/file=public,/s=authenticated")
+	if err != nil {
+		t.Fatalf("ParseRoutePolicies: %v", err)
+	}
+
+	want := map[string]auth.Policy{
+		"/qrcode/png":            auth.PolicyLocalhost,
+		"/qrcode/file/":          auth.PolicyLocalhost,
+		"/file/":                 auth.PolicyPublic,
+		"/clipboard/api/entries": auth.RolePolicy(auth.PermAdmin),
+		"/clipboard/savefile":    auth.RolePolicy(auth.PermAdmin),
+		"/clipboard/host":        auth.PolicyPublic,
+		"/s/":                    auth.PolicyAuthenticated,
+	}
+	for _, rt := range allRoutes() {
+		got := rp.Lookup(rt.pattern, rt.policy)
+		if p, ok := want[rt.pattern]; ok && got != p {
+			t.Errorf("Lookup(%s) = %s, want %s", rt.pattern, got, p)
+		}
+		if strings.HasPrefix(rt.pattern, "/qrcode") && got != auth.PolicyLocalhost {
+			t.Errorf("/qrcode does not cover %s", rt.pattern)
+		}
+		if strings.HasPrefix(rt.pattern, "/share") && got != rt.policy {
+			t.Errorf("/s overrides %s", rt.pattern)
+		}
+	}
+}
+
+// testGuard is the app's guard and routes with test users, a real session
+// store, ban list and device list
+type testGuard struct {
+	mux      *http.ServeMux
+	sessions *auth.SessionStore
+	bans     *auth.Banner
+	devices  *auth.Devices
+}
+
+func newTestGuard(t *testing.T, routes string, noAuth bool) *testGuard {
+	t.Helper()
+	users, err := auth.NewUsers([]auth.User{
+		{Credentials: auth.Credentials{User: "admin", Password: "secret"}, Permissions: []auth.Permission{auth.PermAdmin}},
+		{Credentials: auth.Credentials{User: "mom", Password: "mompw"}, Permissions: []auth.Permission{auth.PermDownload, auth.PermClipboard}},
+		{Credentials: auth.Credentials{User: "kid", Password: "kidpw"}, Permissions: []auth.Permission{auth.PermClipboard}},
+	})
+	if err != nil {
+		t.Fatalf("NewUsers: %v", err)
+	}
+	rp, err := auth.ParseRoutePolicies(routes)
+	if err != nil {
+		t.Fatalf("ParseRoutePolicies: %v", err)
+	}
+	sessions, err := auth.NewSessionStore(time.Hour, loginPattern)
+	if err != nil {
+		t.Fatalf("NewSessionStore: %v", err)
+	}
+
+	g := &testGuard{
+		mux:      http.NewServeMux(),
+		sessions: sessions,
+		bans:     auth.NewBanner(3, time.Minute),
+		devices:  auth.NewDevices(),
+	}
+	guard := auth.NewGuard(users, noAuth, g.bans, g.sessions, g.devices)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, rt := range allRoutes() {
+		g.mux.Handle(rt.pattern, guard.Protect(rp.Lookup(rt.pattern, rt.policy), ok))
+	}
+	return g
+}
+
+// session returns the cookie and CSRF token of a new session of user
+func (g *testGuard) session(t *testing.T, user string) (*http.Cookie, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := g.sessions.Create(w, httptest.NewRequest(http.MethodGet, "/login", nil), user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	c := w.Result().Cookies()[0]
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(c)
+	return c, auth.CSRFToken(g.sessions.WithCSRFToken(r))
+}
+
+func (g *testGuard) serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	g.mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestGuardProtect(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		noAuth bool
+		method string
+		path   string
+		user   string
+		pwd    string
+		remote string
+		want   int
+	}{
+		{name: "public", path: "/s/abc", want: http.StatusOK},
+		{name: "public pairing", path: "/pair", want: http.StatusOK},
+		{name: "public with wrong password", path: "/login", user: "admin", pwd: "wrong", want: http.StatusOK},
+		{name: "browser sent to login", path: "/qrcode", want: http.StatusSeeOther},
+		{name: "script without credentials", method: http.MethodPost, path: "/clipboard/generate", want: http.StatusUnauthorized},
+		{name: "authenticated", path: "/qrcode/svg", user: "kid", pwd: "kidpw", want: http.StatusOK},
+		{name: "wrong password", path: "/qrcode", user: "kid", pwd: "mompw", want: http.StatusUnauthorized},
+		{name: "unknown user", path: "/qrcode", user: "dad", pwd: "kidpw", want: http.StatusUnauthorized},
+		{name: "role granted", path: "/file/a.txt", user: "mom", pwd: "mompw", want: http.StatusOK},
+		{name: "role denied", path: "/file/a.txt", user: "kid", pwd: "kidpw", want: http.StatusForbidden},
+		{name: "admin has every role", path: "/upload", user: "admin", pwd: "secret", want: http.StatusOK},
+		{name: "admin role denied", path: "/clipboard/host", user: "mom", pwd: "mompw", want: http.StatusForbidden},
+		{name: "admin api denied", path: "/devices/api", user: "mom", pwd: "mompw", want: http.StatusForbidden},
+		{name: "clipboard role", path: "/clipboard/events", user: "kid", pwd: "kidpw", want: http.StatusOK},
+		{name: "upload role below clipboard", method: http.MethodPost, path: "/clipboard/savefile", user: "kid", pwd: "kidpw", want: http.StatusForbidden},
+		{name: "no auth", noAuth: true, path: "/bans/api", want: http.StatusOK},
+		{name: "override to public", routes: "/file=public", path: "/file/a.txt", want: http.StatusOK},
+		{name: "override to role", routes: "/qrcode=role:admin", path: "/qrcode/png", user: "kid", pwd: "kidpw", want: http.StatusForbidden},
+		{name: "override keeps others", routes: "/qrcode=role:admin", path: "/upload", user: "kid", pwd: "kidpw", want: http.StatusForbidden},
+		{name: "localhost from the PC", routes: "/qrcode=localhost", path: "/qrcode/txt", remote: "127.0.0.1:50000", want: http.StatusOK},
+		{name: "localhost from elsewhere", routes: "/qrcode=localhost", path: "/qrcode", user: "admin", pwd: "secret", want: http.StatusForbidden},
+		{name: "localhost with no auth", routes: "/qrcode=localhost", noAuth: true, path: "/qrcode", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGuard(t, tt.routes, tt.noAuth)
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tt.path, nil)
+			r.RemoteAddr = testRemote
+			if tt.remote != "" {
+				r.RemoteAddr = tt.remote
+			}
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.pwd)
+			}
+
+			if w := g.serve(r); w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuardSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		method string
+		path   string
+		csrf   bool
+		want   int
+	}{
+		{name: "page", user: "kid", method: http.MethodGet, path: "/clipboard", want: http.StatusOK},
+		{name: "role denied", user: "kid", method: http.MethodGet, path: "/bans", want: http.StatusForbidden},
+		{name: "post with csrf token", user: "kid", method: http.MethodPost, path: "/clipboard/generate", csrf: true, want: http.StatusOK},
+		{name: "post without csrf token", user: "kid", method: http.MethodPost, path: "/clipboard/generate", want: http.StatusForbidden},
+		{name: "admin delete", user: "admin", method: http.MethodDelete, path: "/devices/api", csrf: true, want: http.StatusOK},
+		{name: "admin delete without csrf token", user: "admin", method: http.MethodDelete, path: "/devices/api", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGuard(t, "", false)
+			cookie, token := g.session(t, tt.user)
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.RemoteAddr = testRemote
+			r.AddCookie(cookie)
+			if tt.csrf {
+				r.Header.Set(auth.CSRFHeader, token)
+			}
+
+			if w := g.serve(r); w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+
+	// a forged or ended session counts as none
+	g := newTestGuard(t, "", false)
+	cookie, _ := g.session(t, "kid")
+	forged := &http.Cookie{Name: cookie.Name, Value: cookie.Value + "x"}
+	r := httptest.NewRequest(http.MethodGet, "/clipboard", nil)
+	r.AddCookie(forged)
+	if w := g.serve(r); w.Code != http.StatusSeeOther {
+		t.Errorf("forged session = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/clipboard", nil)
+	r.AddCookie(cookie)
+	g.sessions.Destroy(httptest.NewRecorder(), r)
+	if w := g.serve(r); w.Code != http.StatusSeeOther {
+		t.Errorf("ended session = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
+
+func TestGuardBans(t *testing.T) {
+	g := newTestGuard(t, "", false)
+	request := func(pwd string) int {
+		r := httptest.NewRequest(http.MethodGet, "/qrcode", nil)
+		r.RemoteAddr = testRemote
+		r.SetBasicAuth("kid", pwd)
+		return g.serve(r).Code
+	}
+
+	for i := 1; i < 3; i++ {
+		if got := request("wrong"); got != http.StatusUnauthorized {
+			t.Fatalf("failure #%d = %d, want %d", i, got, http.StatusUnauthorized)
+		}
+	}
+	if got := request("wrong"); got != http.StatusTooManyRequests {
+		t.Fatalf("third failure = %d, want %d", got, http.StatusTooManyRequests)
+	}
+	if got := request("kidpw"); got != http.StatusTooManyRequests {
+		t.Errorf("right password while banned = %d, want %d", got, http.StatusTooManyRequests)
+	}
+
+	// other clients and public routes are not affected
+	r := httptest.NewRequest(http.MethodGet, "/qrcode", nil)
+	r.SetBasicAuth("kid", "kidpw")
+	if w := g.serve(r); w.Code != http.StatusOK {
+		t.Errorf("other client = %d, want %d", w.Code, http.StatusOK)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/s/abc", nil)
+	r.RemoteAddr = testRemote
+	if w := g.serve(r); w.Code != http.StatusOK {
+		t.Errorf("public route while banned = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestGuardDeviceToken(t *testing.T) {
+	g := newTestGuard(t, "", false)
+	dev, err := g.devices.Request("198.51.100.20", "phone")
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if err := g.devices.Approve(dev.ID, "mom"); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	_, token, err := g.devices.Claim(dev.ID)
+	if err != nil || token == "" {
+		t.Fatalf("Claim = %q, %v", token, err)
+	}
+
+	// the device token turns into a session and the page is loaded again
+	r := httptest.NewRequest(http.MethodGet, "/file/a.txt", nil)
+	r.RemoteAddr = testRemote
+	r.AddCookie(&http.Cookie{Name: auth.DeviceCookieName, Value: token})
+	w := g.serve(r)
+	if w.Code != http.StatusSeeOther || !strings.HasSuffix(w.Header().Get("Location"), "/file/a.txt") {
+		t.Fatalf("device token = %d to %q, want a redirect back", w.Code, w.Header().Get("Location"))
+	}
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == auth.SessionCookieName {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie for the device")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/file/a.txt", nil)
+	r.RemoteAddr = testRemote
+	r.AddCookie(session)
+	if w := g.serve(r); w.Code != http.StatusOK {
+		t.Errorf("device session = %d, want %d", w.Code, http.StatusOK)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/bans", nil)
+	r.RemoteAddr = testRemote
+	r.AddCookie(session)
+	if w := g.serve(r); w.Code != http.StatusForbidden {
+		t.Errorf("device session on an admin page = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// a revoked or unknown token does not log in
+	if err := g.devices.Revoke(dev.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/file/a.txt", nil)
+	r.RemoteAddr = testRemote
+	r.AddCookie(&http.Cookie{Name: auth.DeviceCookieName, Value: token})
+	w = g.serve(r)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || !strings.Contains(loc, loginPattern) {
+		t.Errorf("revoked device token = %d to %q, want the login page", w.Code, loc)
+	}
+}
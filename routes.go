@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/auth"
+	"github.com/kumakichi/pc-mobile-file-exchanger/internal/handlers"
+)
+
+// route is a handler and the default policy protecting it
+type route struct {
+	pattern string
+	policy  auth.Policy
+	handler http.Handler
+}
+
+// appHandlers are the handlers behind the routes, the routes of nil
+// optional handlers are left out
+type appHandlers struct {
+	qrcode        *handlers.QRCodeHandler
+	file          *handlers.FileHandler
+	upload        *handlers.UploadHandler
+	clipboard     *handlers.ClipboardHandler
+	clipboardFile *handlers.ClipboardFileHandler
+	clipboardSync *handlers.ClipboardSyncHandler
+	share         *handlers.ShareHandler
+	// hostClipboard is set with -hostClip
+	hostClipboard *handlers.HostClipboardHandler
+	// login, ban and device are set when logins are required
+	login  *handlers.LoginHandler
+	ban    *handlers.BanHandler
+	device *handlers.DeviceHandler
+	// pair is set when devices may pair by QR code
+	pair http.Handler
+}
+
+// appRoutes returns every route of the app with its default policy
+func appRoutes(h appHandlers) []route {
+	download := auth.RolePolicy(auth.PermDownload)
+	upload := auth.RolePolicy(auth.PermUpload)
+	clip := auth.RolePolicy(auth.PermClipboard)
+	admin := auth.RolePolicy(auth.PermAdmin)
+
+	routes := []route{
+		{qrPattern, auth.PolicyAuthenticated, http.HandlerFunc(h.qrcode.QRCodeHandler)},
+		{qrPattern + "/png", auth.PolicyAuthenticated, http.HandlerFunc(h.qrcode.ImageHandler)},
+		{qrPattern + "/svg", auth.PolicyAuthenticated, http.HandlerFunc(h.qrcode.ImageHandler)},
+		{qrPattern + "/txt", auth.PolicyAuthenticated, http.HandlerFunc(h.qrcode.ImageHandler)},
+		{qrPattern + "/file/", download, http.HandlerFunc(h.qrcode.FileQRCodeHandler)},
+		{filePattern, download, http.StripPrefix(filePattern, http.HandlerFunc(h.file.ServeFiles))},
+		{uploadPattern, upload, http.HandlerFunc(h.upload.HandleUpload)},
+		{clipboardPattern, clip, http.HandlerFunc(h.clipboard.ClipboardIndexHandler)},
+		{clipboardPattern + "/generate", clip, http.HandlerFunc(h.clipboard.GenerateClipboardCode)},
+		{clipboardPattern + "/retrieve", clip, http.HandlerFunc(h.clipboard.RetrieveClipboardContent)},
+		{clipboardPattern + "/history", clip, http.HandlerFunc(h.clipboard.HistoryHandler)},
+		{clipboardPattern + "/api/entries", clip, http.HandlerFunc(h.clipboard.EntriesAPIHandler)},
+		{clipboardPattern + "/savefile", upload, http.HandlerFunc(h.clipboardFile.SaveFileHandler)},
+		{clipboardPattern + "/loadfile", download, http.HandlerFunc(h.clipboardFile.LoadFileHandler)},
+		{clipboardPattern + "/sync", clip, http.HandlerFunc(h.clipboardSync.PublishHandler)},
+		{clipboardPattern + "/events", clip, http.HandlerFunc(h.clipboardSync.EventsHandler)},
+		{clipboardPattern + "/attachment", clip, http.HandlerFunc(h.clipboard.AttachmentHandler)},
+		{sharePattern, download, http.HandlerFunc(h.share.ManageHandler)},
+		{sharePattern + "/revoke", download, http.HandlerFunc(h.share.RevokeHandler)},
+		// share links carry their own token, no main credentials needed
+		{sharedPattern, auth.PolicyPublic, http.HandlerFunc(h.share.ServeShare)},
+	}
+
+	if h.hostClipboard != nil {
+		// the PC clipboard belongs to the owner, not to every clipboard user
+		routes = append(routes, route{clipboardPattern + "/host", admin, http.HandlerFunc(h.hostClipboard.HostClipboard)})
+	}
+
+	if h.login != nil {
+		routes = append(routes,
+			route{loginPattern, auth.PolicyPublic, http.HandlerFunc(h.login.Login)},
+			route{logoutPattern, auth.PolicyAuthenticated, http.HandlerFunc(h.login.Logout)},
+		)
+	}
+	if h.ban != nil {
+		routes = append(routes,
+			route{bansPattern, admin, http.HandlerFunc(h.ban.BansHandler)},
+			route{bansPattern + "/api", admin, http.HandlerFunc(h.ban.BansAPIHandler)},
+			route{bansPattern + "/api/allow", admin, http.HandlerFunc(h.ban.AllowAPIHandler)},
+		)
+	}
+	if h.device != nil {
+		routes = append(routes,
+			// new devices ask for access here, the owner approves them on the devices page
+			route{devicePattern, auth.PolicyPublic, http.HandlerFunc(h.device.RequestHandler)},
+			route{devicesPattern, admin, http.HandlerFunc(h.device.DevicesHandler)},
+			route{devicesPattern + "/api", admin, http.HandlerFunc(h.device.DevicesAPIHandler)},
+		)
+	}
+
+	if h.pair != nil {
+		routes = append(routes, route{pairPattern, auth.PolicyPublic, h.pair})
+	}
+	return routes
+}